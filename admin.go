@@ -0,0 +1,136 @@
+package class_notify
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/zMrKrabz/class-notify/schools"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+func (d *Discord) admin(s Session, i *discordgo.InteractionCreate) {
+	userID := interactionUserID(i)
+	if !contains(d.Operators, userID) {
+		reply(s, i, "only operators of the bot can use /admin", true)
+		return
+	}
+
+	if !deferReply(s, i, true) {
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := optionMap(subcommand.Options)
+	logger := interactionLogger(i)
+	logger.Info("operator used /admin", "subcommand", subcommand.Name)
+	switch subcommand.Name {
+	case "stats":
+		editReply(s, i, d.stats())
+	case "pause":
+		d.Bot.Pause()
+		editReply(s, i, "Paused monitoring, classes will not be checked until /admin resume")
+	case "resume":
+		d.Bot.Resume()
+		editReply(s, i, "Resumed monitoring")
+	case "forcecheck":
+		uri := options["url"].StringValue()
+		event, err := d.Bot.ForceCheck(uri, d.guildSettings(i).DefaultSchool)
+		if err != nil {
+			editReply(s, i, "Unable to check the class: "+explain(err))
+			logger.Warn("force check failed", "event", uri, "err", err)
+			return
+		}
+		editReply(s, i, fmt.Sprintf("%s is %s", event.ClassDetails.Name, event.ClassDetails.Status))
+	case "remove-event":
+		uri := options["url"].StringValue()
+		event, err := d.Bot.RemoveEvent(uri, d.guildSettings(i).DefaultSchool)
+		if err != nil {
+			editReply(s, i, "Unable to remove the class: "+explain(err))
+			logger.Warn("removing event failed", "event", uri, "err", err)
+			return
+		}
+		editReply(s, i, fmt.Sprintf("Removed class %s with %d subscribers and %d channels",
+			event.ClassDetails.Name, len(event.Subscribers), len(event.Channels)))
+	case "broadcast":
+		d.broadcast(s, i, options["message"].StringValue())
+	default:
+		editReply(s, i, "unknown admin command")
+	}
+}
+
+// stats describes the events, subscribers, last check and health of every school
+func (d *Discord) stats() string {
+	cycles := make(map[string]CycleStats)
+	for _, c := range d.Bot.Cycles() {
+		cycles[c.School] = c
+	}
+	health := make(map[string]schools.SchoolHealth)
+	if d.Bot.Health != nil {
+		for _, h := range d.Bot.Health.Snapshot() {
+			health[h.School] = h
+		}
+	}
+
+	var b strings.Builder
+	if d.Bot.Paused() {
+		b.WriteString("**monitoring is paused**\n")
+	}
+	for _, id := range d.Bot.Schools.IDs() {
+		isDefault := id == d.Bot.Schools.Default
+		events, err := d.Bot.DB.GetActiveEventsCount(id, isDefault)
+		if err != nil {
+			slog.Warn("counting events failed", "school", id, "err", err)
+		}
+		subscribers, err := d.Bot.DB.GetActiveSubscribersCount(id, isDefault)
+		if err != nil {
+			slog.Warn("counting subscribers failed", "school", id, "err", err)
+		}
+		fmt.Fprintf(&b, "**%s**: %d events, %d subscriptions", id, events, subscribers)
+		if c, ok := cycles[id]; ok {
+			fmt.Fprintf(&b, ", last cycle took %s %s ago", c.Duration.Round(time.Millisecond),
+				time.Since(c.Started).Round(time.Second))
+		}
+		if h, ok := health[id]; ok {
+			fmt.Fprintf(&b, ", %.1f%% of recent checks failing out of %d", h.FailureRate*100, h.Checks)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// broadcast sends message to every subscriber and channel of an active event
+func (d *Discord) broadcast(s Session, i *discordgo.InteractionCreate, message string) {
+	logger := interactionLogger(i)
+	users, channels, err := d.Bot.Recipients()
+	if err != nil {
+		editReply(s, i, "Unable to list subscribers: "+explain(err))
+		logger.Warn("listing broadcast recipients failed", "err", err)
+		return
+	}
+	editReply(s, i, fmt.Sprintf("Broadcasting to %d users and %d channels", len(users), len(channels)))
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Notice from the class-notify operators",
+		Description: message,
+		Color:       0xf1c40f,
+	}
+	failed := 0
+	for _, user := range users {
+		channel, err := s.UserChannelCreate(user)
+		if err == nil {
+			_, err = s.ChannelMessageSendEmbed(channel.ID, embed)
+		}
+		if err != nil {
+			failed++
+			logger.Warn("broadcasting to user failed", slog.Group("recipient", userKey, user), "err", err)
+		}
+	}
+	for _, channel := range channels {
+		if _, err := s.ChannelMessageSendEmbed(channel.ChannelID, embed); err != nil {
+			failed++
+			logger.Warn("broadcasting to channel failed", "channel", channel.ChannelID, "err", err)
+		}
+	}
+	logger.Info("broadcast sent", "recipients", len(users)+len(channels)-failed, "failed", failed)
+}
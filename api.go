@@ -0,0 +1,264 @@
+package class_notify
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// API serves subscriptions and class statuses over http to other applications,
+// acting for any user on behalf of the applications holding its keys
+type API struct {
+	Bot *Bot
+	// Keys maps the api keys to the name of the application using them
+	Keys map[string]string
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	maxRequestBody      = 1 << 16
+)
+
+// apiClass is an event as shown by the API, which leaves out who subscribed to it
+type apiClass struct {
+	URI               string              `json:"uri"`
+	School            string              `json:"school"`
+	Name              string              `json:"name"`
+	Status            schools.ClassStatus `json:"status"`
+	SeatsTotal        int                 `json:"seats_total"`
+	SeatsRemaining    int                 `json:"seats_remaining"`
+	WaitlistTotal     int                 `json:"waitlist_total"`
+	WaitlistRemaining int                 `json:"waitlist_remaining"`
+	Subscribers       int                 `json:"subscribers"`
+	Channels          int                 `json:"channels"`
+}
+
+func newAPIClass(event Event) apiClass {
+	return apiClass{
+		URI:               event.URI,
+		School:            event.School,
+		Name:              event.ClassDetails.Name,
+		Status:            event.ClassDetails.Status,
+		SeatsTotal:        event.ClassDetails.SeatsTotal,
+		SeatsRemaining:    event.ClassDetails.SeatsRemaining,
+		WaitlistTotal:     event.ClassDetails.WaitlistTotal,
+		WaitlistRemaining: event.ClassDetails.WaitlistRemaining,
+		Subscribers:       len(event.Subscribers),
+		Channels:          len(event.Channels),
+	}
+}
+
+// subscriptionRequest is the body of a new subscription, School is the one of
+// course identifiers without a school, the default school when empty
+type subscriptionRequest struct {
+	Class  string `json:"class"`
+	School string `json:"school"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// Handler routes the requests of the API, every route but the specification and
+// the stream requires a key
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	mux.Handle("GET /api/v1/users/{user}/subscriptions", a.authenticate(a.subscriptions))
+	mux.Handle("POST /api/v1/users/{user}/subscriptions", a.authenticate(a.subscribe))
+	mux.Handle("DELETE /api/v1/users/{user}/subscriptions", a.authenticate(a.unsubscribe))
+	mux.Handle("GET /api/v1/classes", a.authenticate(a.class))
+	mux.Handle("GET /api/v1/classes/history", a.authenticate(a.history))
+	// changes carry no user ids, so that dashboards can listen without a key
+	mux.HandleFunc("GET /api/v1/stream", func(w http.ResponseWriter, r *http.Request) {
+		if a.Bot.Stream == nil {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "streaming is disabled"})
+			return
+		}
+		a.Bot.Stream.serve(w, r, a.Bot.DB)
+	})
+	return mux
+}
+
+// apiHandler handles a request of the application client, logging with logger
+type apiHandler func(w http.ResponseWriter, r *http.Request, logger *slog.Logger)
+
+// authenticate only lets requests bearing one of the keys through
+func (a *API) authenticate(h apiHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		client, known := a.client(key)
+		if !ok || !known {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="class-notify"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "a valid api key is required"})
+			return
+		}
+		logger := slog.With("api_client", client, "method", r.Method, "path", r.URL.Path)
+		if user := r.PathValue("user"); user != "" {
+			logger = logger.With(userKey, user)
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		h(w, r, logger)
+	})
+}
+
+// client returns the name of the application holding key, comparing every key in
+// constant time
+func (a *API) client(key string) (string, bool) {
+	sum := sha256.Sum256([]byte(key))
+	var client string
+	found := 0
+	for k, name := range a.Keys {
+		other := sha256.Sum256([]byte(k))
+		if subtle.ConstantTimeCompare(sum[:], other[:]) == 1 {
+			client = name
+			found = 1
+		}
+	}
+	return client, found == 1 && key != ""
+}
+
+func (a *API) subscriptions(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	events, err := a.Bot.GetUserEvents(r.PathValue("user"))
+	if err != nil {
+		logger.Warn("listing subscriptions failed", "err", err)
+		writeAPIError(w, err)
+		return
+	}
+	classes := make([]apiClass, len(events))
+	for i, event := range events {
+		classes[i] = newAPIClass(event)
+	}
+	writeJSON(w, http.StatusOK, classes)
+}
+
+func (a *API) subscribe(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Class == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: `the body must be a json object with a "class" url or course identifier`})
+		return
+	}
+	event, err := a.Bot.Subscribe(req.Class, req.School, r.PathValue("user"))
+	if err != nil {
+		logger.Warn("subscribing failed", "class", req.Class, "err", err)
+		writeAPIError(w, err)
+		return
+	}
+	logger.Info("subscribed through the api", "event", event.URI)
+	if !contains(event.Subscribers, r.PathValue("user")) {
+		event.Subscribers = append(event.Subscribers, r.PathValue("user"))
+	}
+	writeJSON(w, http.StatusCreated, newAPIClass(event))
+}
+
+func (a *API) unsubscribe(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	class := r.URL.Query().Get("class")
+	if class == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: `the "class" query parameter is required`})
+		return
+	}
+	event, err := a.Bot.Unsubscribe(class, r.URL.Query().Get("school"), r.PathValue("user"))
+	if err != nil {
+		if errors.Is(err, ErrNoSuchEvent) {
+			err = ErrNotSubscribed
+		}
+		logger.Warn("unsubscribing failed", "class", class, "err", err)
+		writeAPIError(w, err)
+		return
+	}
+	logger.Info("unsubscribed through the api", "event", event.URI)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) class(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	class := r.URL.Query().Get("class")
+	if class == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: `the "class" query parameter is required`})
+		return
+	}
+	event, err := a.Bot.Event(class, r.URL.Query().Get("school"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIClass(event))
+}
+
+func (a *API) history(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	class := r.URL.Query().Get("class")
+	if class == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: `the "class" query parameter is required`})
+		return
+	}
+	limit := defaultHistoryLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a number from 1 to " + strconv.Itoa(maxHistoryLimit)})
+			return
+		}
+		limit = n
+	}
+	if _, err := a.Bot.Event(class, r.URL.Query().Get("school")); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	entries, err := a.Bot.History(class, r.URL.Query().Get("school"), limit)
+	if err != nil {
+		logger.Warn("getting history failed", "class", class, "err", err)
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// writeAPIError answers with the status and explanation matching err
+func writeAPIError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), apiError{Error: explain(err)})
+}
+
+// errorStatus returns the http status matching err
+func errorStatus(err error) int {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNoSuchEvent), errors.Is(err, ErrNotSubscribed):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrAlreadySubscribed):
+		status = http.StatusConflict
+	case errors.Is(err, schools.ErrUnknownSchool), errors.Is(err, schools.ErrInvalidClass):
+		status = http.StatusBadRequest
+	case schools.IsUnavailable(err):
+		status = http.StatusBadGateway
+	default:
+		var parseErr *schools.ParseError
+		var statusErr *schools.StatusError
+		if errors.As(err, &parseErr) {
+			status = http.StatusBadGateway
+		} else if errors.As(err, &statusErr) {
+			// the registrar does not know the class
+			status = http.StatusBadRequest
+		}
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,150 @@
+package class_notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+const testAPIKey = "secret-key"
+
+func newTestAPI(t *testing.T, events ...Event) (*testBot, *httptest.Server) {
+	t.Helper()
+	tb := newTestBot(t, events...)
+	api := &API{Bot: tb.discord.Bot, Keys: map[string]string{testAPIKey: "planner"}}
+	server := httptest.NewServer(api.Handler())
+	t.Cleanup(server.Close)
+	return tb, server
+}
+
+func apiRequest(t *testing.T, server *httptest.Server, method string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	_, server := newTestAPI(t)
+	for _, header := range []string{"", "Bearer wrong", "Bearer ", testAPIKey} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/users/user/subscriptions", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q = %d, want 401", header, resp.StatusCode)
+		}
+	}
+
+	resp, err := server.Client().Get(server.URL + "/api/v1/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("specification = %d, want it served without a key", resp.StatusCode)
+	}
+}
+
+func TestAPISubscriptions(t *testing.T) {
+	tb, server := newTestAPI(t, Event{URI: otherClass, School: "TEST", Subscribers: []string{"someone"}, ClassDetails: fullDetails})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"subscribe to a new class", "POST", "/api/v1/users/user/subscriptions", `{"class": "` + testClass + `"}`, 201, `"subscribers":1`},
+		{"subscribe to a tracked class", "POST", "/api/v1/users/user/subscriptions", `{"class": "` + otherClass + `"}`, 201, `"subscribers":2`},
+		{"subscribe twice", "POST", "/api/v1/users/user/subscriptions", `{"class": "` + otherClass + `"}`, 409, "already subscribed"},
+		{"subscribe without a class", "POST", "/api/v1/users/user/subscriptions", `{}`, 400, "json object"},
+		{"subscribe to another school", "POST", "/api/v1/users/user/subscriptions", `{"class": "https://elsewhere.test/1"}`, 400, "not the url of a class"},
+		{"subscribe to an unknown class", "POST", "/api/v1/users/user/subscriptions", `{"class": "` + fakeSchoolURL + `missing"}`, 400, "could not find this class"},
+		{"list subscriptions", "GET", "/api/v1/users/user/subscriptions", "", 200, otherClass},
+		{"unsubscribe", "DELETE", "/api/v1/users/user/subscriptions?class=" + otherClass, "", 204, ""},
+		{"unsubscribe twice", "DELETE", "/api/v1/users/user/subscriptions?class=" + otherClass, "", 404, "not subscribed"},
+		{"unsubscribe from an untracked class", "DELETE", "/api/v1/users/user/subscriptions?class=" + fakeSchoolURL + "other", "", 404, "not subscribed"},
+	}
+	for _, tt := range tests {
+		status, body := apiRequest(t, server, tt.method, tt.path, tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %d %s, want %d containing %q", tt.name, status, body, tt.status, tt.want)
+		}
+	}
+
+	events, _ := tb.store.GetEventsWithSubscriber("user")
+	if len(events) != 1 || events[0].URI != testClass {
+		t.Errorf("subscriptions of user = %v, want only %s", events, testClass)
+	}
+	status, body := apiRequest(t, server, "GET", "/api/v1/users/user/subscriptions", "")
+	if status != 200 || strings.Contains(body, "someone") {
+		t.Errorf("subscriptions = %s, want no user ids", body)
+	}
+}
+
+func TestAPIClassHistory(t *testing.T) {
+	tb, server := newTestAPI(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+	now := time.Now()
+	for i, status := range []schools.ClassStatus{schools.OPENED, schools.FULL, schools.WAITLISTED} {
+		tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Status: status, At: now.Add(time.Duration(i) * time.Minute)})
+	}
+	tb.store.AddHistory(HistoryEntry{URI: otherClass, School: "TEST", Status: schools.OPENED, At: now})
+
+	status, body := apiRequest(t, server, "GET", "/api/v1/classes?class="+testClass, "")
+	var class apiClass
+	if status != 200 || json.Unmarshal([]byte(body), &class) != nil || class.Status != schools.FULL || class.Subscribers != 1 {
+		t.Errorf("class = %d %s, want the stored FULL class", status, body)
+	}
+
+	status, body = apiRequest(t, server, "GET", "/api/v1/classes/history?limit=2&class="+testClass, "")
+	var entries []HistoryEntry
+	if err := json.Unmarshal([]byte(body), &entries); status != 200 || err != nil {
+		t.Fatalf("history = %d %s", status, body)
+	}
+	if len(entries) != 2 || entries[0].Status != schools.FULL || entries[1].Status != schools.WAITLISTED {
+		t.Errorf("history = %+v, want the last two changes oldest first", entries)
+	}
+
+	for _, path := range []string{"/api/v1/classes?class=" + otherClass, "/api/v1/classes/history?class=" + otherClass} {
+		if status, body := apiRequest(t, server, "GET", path, ""); status != 404 {
+			t.Errorf("%s = %d %s, want 404 for an untracked class", path, status, body)
+		}
+	}
+	if status, _ := apiRequest(t, server, "GET", "/api/v1/classes/history?limit=0&class="+testClass, ""); status != 400 {
+		t.Errorf("limit 0 = %d, want 400", status)
+	}
+}
+
+func TestHistoryIsRecorded(t *testing.T) {
+	tb := newTestBot(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+	if err := tb.discord.Bot.Monitor("TEST", tb.discord.UpdateSubscriber); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := tb.store.GetHistory(HistoryFilter{URI: testClass})
+	if len(entries) != 1 || entries[0].Status != schools.OPENED || entries[0].Previous != schools.FULL || entries[0].SeatsRemaining != 2 {
+		t.Errorf("history = %+v, want the change to OPENED", entries)
+	}
+}
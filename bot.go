@@ -4,109 +4,520 @@ import (
 	"errors"
 	"fmt"
 	"github.com/zMrKrabz/class-notify/schools"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 )
 
 type Bot struct {
-	School schools.ISchool
-	DB     *Database
+	Schools *schools.Registry
+	DB      Store
+	// Health, when set, is told the outcome of every class check
+	Health *schools.Health
+	// Debounce holds back alerts of new statuses until they settle
+	Debounce Debounce
+	// Metrics, when set, counts checks and status changes
+	Metrics *Metrics
+	// Stream, when set, is sent every change recorded in the history
+	Stream *Stream
+
+	mu     sync.Mutex
+	paused bool
+	cycles map[string]CycleStats
+	// progress is when every school last completed a check or a cycle
+	progress map[string]time.Time
+}
+
+// Debounce is how long a new status must last before it is alerted, both checks
+// and duration must be reached. The zero value alerts every change right away.
+type Debounce struct {
+	Checks   int
+	Duration time.Duration
+}
+
+// Settled reports whether pending lasted long enough at now to be alerted
+func (d Debounce) Settled(pending Pending, now time.Time) bool {
+	return pending.Checks >= d.Checks && now.Sub(pending.Since) >= d.Duration
+}
+
+// CycleStats describes the last check of every event of a school
+type CycleStats struct {
+	School   string
+	Started  time.Time
+	Duration time.Duration
+	Events   int64
+}
+
+// StartMonitor checks the events of every school, each at its own poll interval
+func (bot *Bot) StartMonitor(updateFn func(change Change) error) {
+	var wg sync.WaitGroup
+	for _, id := range bot.Schools.IDs() {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for {
+				if bot.Paused() {
+					time.Sleep(bot.Schools.PollInterval(id))
+					continue
+				}
+				started := time.Now()
+				err := bot.Monitor(id, updateFn)
+				if err != nil {
+					slog.Error("monitoring failed", "school", id, "err", err)
+				}
+				bot.recordCycle(id, started, err)
+				time.Sleep(bot.Schools.PollInterval(id))
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+// Pause stops checking classes until Resume is called
+func (bot *Bot) Pause() {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	bot.paused = true
+}
+
+func (bot *Bot) Resume() {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	bot.paused = false
+}
+
+func (bot *Bot) Paused() bool {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	return bot.paused
+}
+
+func (bot *Bot) recordCycle(schoolID string, started time.Time, err error) {
+	stats := CycleStats{School: schoolID, Started: started, Duration: time.Since(started)}
+	bot.Metrics.cycle(schoolID, stats.Duration)
+	if count, err := bot.DB.GetActiveEventsCount(schoolID, schoolID == bot.Schools.Default); err == nil {
+		stats.Events = count
+	}
+	bot.recordProgress(schoolID, time.Now())
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	if bot.cycles == nil {
+		bot.cycles = make(map[string]CycleStats)
+	}
+	bot.cycles[schoolID] = stats
+}
+
+func (bot *Bot) recordProgress(schoolID string, at time.Time) {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	if bot.progress == nil {
+		bot.progress = make(map[string]time.Time)
+	}
+	bot.progress[schoolID] = at
 }
 
-func (bot *Bot) StartMonitor(updateFn func(event Event) error) {
-	for {
-		if err := bot.Monitor(updateFn); err != nil {
-			log.Printf("error on monitoring: %s\n", err)
+// Progress returns when schoolID last completed a check or a monitoring cycle,
+// whether it failed or not. It is zero until then.
+func (bot *Bot) Progress(schoolID string) time.Time {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	return bot.progress[schoolID]
+}
+
+// Cycles returns the last monitoring cycle of every school that completed one
+func (bot *Bot) Cycles() []CycleStats {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	cycles := make([]CycleStats, 0, len(bot.cycles))
+	for _, id := range bot.Schools.IDs() {
+		if c, ok := bot.cycles[id]; ok {
+			cycles = append(cycles, c)
 		}
 	}
+	return cycles
 }
 
-func (bot *Bot) Monitor(updateFn func(event Event) error) error {
-	eventCount, err := bot.DB.GetActiveEventsCount()
-	if err != nil {
-		return fmt.Errorf("could not get active event count: %s", err)
+func (bot *Bot) Monitor(schoolID string, updateFn func(change Change) error) error {
+	school, ok := bot.Schools.Get(schoolID)
+	if !ok {
+		return fmt.Errorf("no school registered as %s", schoolID)
 	}
-	log.Printf("checking %d events \n", eventCount)
-	events:= make(chan Event, eventCount)
-	if err := bot.DB.GetAllActiveEvents(events); err != nil {
+	isDefault := schoolID == bot.Schools.Default
+	events, err := bot.DB.GetAllActiveEvents(schoolID, isDefault)
+	if err != nil {
 		return fmt.Errorf("unable to get active events: %s", err)
 	}
-	for event := range events {
-		log.Printf("checking event %s status", event.URI)
-		if err := bot.checkEventStatus(event, updateFn); err != nil {
-			log.Printf("failed on checking event %s status: %v\n", event, err)
+	slog.Debug("checking events", "school", schoolID, "events", len(events))
+
+	// events of schools listing many classes on one page are checked a page at a time
+	batchSchool, canBatch := school.(schools.BatchSchool)
+	batches := make(map[string][]Event)
+	for _, event := range events {
+		// events stored before they were tagged belong to the default school
+		event.School = schoolID
+		if canBatch {
+			if key := batchSchool.BatchKey(event.URI); key != "" {
+				batches[key] = append(batches[key], event)
+				continue
+			}
 		}
+		bot.checkEventStatus(school, event, newCorrelationID(), updateFn)
+	}
+	for key, batch := range batches {
+		bot.checkBatchStatus(batchSchool, key, batch, updateFn)
 	}
 	return nil
 }
 
-func (bot *Bot) checkEventStatus(event Event, updateFn func(event Event) error) error {
-	details, err := bot.School.GetClassDetails(event.URI)
+// checkBatchStatus checks the events of a batch with one request, the events share
+// the id of the check
+func (bot *Bot) checkBatchStatus(school schools.BatchSchool, key string, events []Event, updateFn func(change Change) error) {
+	checkID := newCorrelationID()
+	logger := slog.With("check", checkID, "school", events[0].School, "batch", key)
+	logger.Debug("checking batch", "events", len(events))
+	uris := make([]string, len(events))
+	for i, event := range events {
+		uris[i] = event.URI
+	}
+	started := time.Now()
+	details, err := school.GetManyClassDetails(uris)
+	bot.Metrics.check(events[0].School, len(uris), started, err)
+	bot.recordProgress(events[0].School, time.Now())
+	if err != nil {
+		if bot.Health != nil {
+			for _, event := range events {
+				bot.Health.Record(event.School, event.URI, err)
+			}
+		}
+		logger.Warn("checking batch failed", "events", len(uris), "err", err)
+		return
+	}
+	for _, event := range events {
+		d, ok := details[event.URI]
+		if !ok {
+			// the listing may leave out some classes, those are checked on their own
+			bot.checkEventStatus(school, event, checkID, updateFn)
+			continue
+		}
+		if bot.Health != nil {
+			bot.Health.Record(event.School, event.URI, nil)
+		}
+		if err := bot.updateEventStatus(event, d, checkID, updateFn); err != nil {
+			logger.Warn("updating event status failed", "event", event.URI, "err", err)
+		}
+	}
+}
+
+func (bot *Bot) checkEventStatus(school schools.ISchool, event Event, checkID string, updateFn func(change Change) error) {
+	logger := slog.With("check", checkID, "school", event.School, "event", event.URI)
+	logger.Debug("checking event")
+	started := time.Now()
+	details, err := school.GetClassDetails(event.URI)
+	bot.Metrics.check(event.School, 1, started, err)
+	bot.recordProgress(event.School, time.Now())
+	if bot.Health != nil {
+		bot.Health.Record(event.School, event.URI, err)
+	}
 	if err != nil {
-		return fmt.Errorf("unable to get class details: %s", err)
+		logger.Warn("getting class details failed", "err", err)
+		return
+	}
+	if err := bot.updateEventStatus(event, details, checkID, updateFn); err != nil {
+		logger.Warn("updating event status failed", "err", err)
 	}
+}
+
+// updateEventStatus stores the latest details of event and calls updateFn once a
+// status different from the one subscribers were last alerted of settles. A better
+// status that does not settle is passed to updateFn as a Brief change. Changes
+// carry checkID to correlate their alerts with the check.
+func (bot *Bot) updateEventStatus(event Event, details schools.ClassDetails, checkID string, updateFn func(change Change) error) error {
 	if err := bot.DB.UpdateEventDetails(event.URI, details); err != nil {
 		return fmt.Errorf("unable to update event with details %s: %s", details, err)
 	}
-	if event.ClassDetails.Status == details.Status {
+	notified := event.Notified
+	if notified.Status == "" {
+		notified = event.ClassDetails
+	}
+	now := time.Now()
+	event.ClassDetails = details
+
+	if details.Status == notified.Status {
+		if event.Pending == nil {
+			return nil
+		}
+		pending := *event.Pending
+		if err := bot.DB.UpdateEventNotification(event.URI, notified, nil); err != nil {
+			return fmt.Errorf("unable to clear pending status of event: %s", err)
+		}
+		if pending.Status != schools.OPENED && pending.Status != schools.WAITLISTED {
+			return nil
+		}
+		slog.Info("status was brief", "check", checkID, "event", event.URI,
+			"status", pending.Status, "duration", now.Sub(pending.Since))
+		change := Change{Event: event, Previous: notified, At: now, CheckID: checkID, Brief: &Brief{
+			Status:   pending.Status,
+			Since:    pending.Since,
+			Duration: now.Sub(pending.Since),
+		}}
+		bot.recordHistory(change)
+		if err := updateFn(change); err != nil {
+			return fmt.Errorf("unable to send update with function on event: %s", err)
+		}
+		return nil
+	}
+
+	pending := Pending{Status: details.Status, Since: now, Checks: 1}
+	if event.Pending != nil && event.Pending.Status == details.Status {
+		pending = *event.Pending
+		pending.Checks++
+	}
+	if !bot.Debounce.Settled(pending, now) {
+		slog.Debug("status is pending", "check", checkID, "event", event.URI, "status", pending.Status, "checks", pending.Checks)
+		if err := bot.DB.UpdateEventNotification(event.URI, notified, &pending); err != nil {
+			return fmt.Errorf("unable to store pending status of event: %s", err)
+		}
 		return nil
 	}
+	if err := bot.DB.UpdateEventNotification(event.URI, details, nil); err != nil {
+		return fmt.Errorf("unable to store notified status of event: %s", err)
+	}
 
-	if err := updateFn(event); err != nil {
+	bot.Metrics.transition(event.School, notified.Status, details.Status)
+	slog.Info("status changed", "check", checkID, "event", event.URI, "from", notified.Status, "to", details.Status)
+	change := Change{Event: event, Previous: notified, At: now, CheckID: checkID}
+	bot.recordHistory(change)
+	if err := updateFn(change); err != nil {
 		return fmt.Errorf("unable to send update with function on event: %s", err)
 	}
 	return nil
 }
 
-func (bot *Bot) Subscribe(uri string, userID string) (Event, error) {
+// Subscribe adds userID to the event of target, which is a class url or a course
+// identifier resolved by the school registry, of defaultSchool when it has no school
+func (bot *Bot) Subscribe(target string, defaultSchool string, userID string) (Event, error) {
+	schoolID, uri, err := bot.Schools.Resolve(target, defaultSchool)
+	if err != nil {
+		return Event{}, fmt.Errorf("resolving school of %s: %w", target, err)
+	}
 	event, err := bot.DB.GetEventWithURI(uri)
 	if err != nil {
 		if errors.Is(err, ErrNoSuchEvent) {
-			event, err := bot.createNewEvent(uri, userID)
+			event, err := bot.createNewEvent(Event{URI: uri, School: schoolID, Subscribers: []string{userID}})
 			if err != nil {
-				return Event{},fmt.Errorf("creating new event with uri %s and usrID %s: %s", uri, userID, err)
+				return Event{}, fmt.Errorf("creating new event with uri %s: %w", uri, err)
 			}
 			return event, nil
 		}
 		return Event{}, fmt.Errorf("getting event %s from database: %s", uri, err)
 	}
 	if err := bot.DB.AddSubscriber(uri, userID); err != nil {
-		return Event{}, fmt.Errorf("adding a subscriber with uri %s and userID %s: %s", uri, userID, err)
+		return Event{}, fmt.Errorf("adding a subscriber with uri %s: %w", uri, err)
 	}
-	log.Printf("Added user %s to event %s", userID, uri)
+	slog.Info("added subscriber", "event", uri, userKey, userID)
 	return event, nil
 }
 
-func (bot *Bot) createNewEvent(uri string, userID string) (Event, error) {
-	details, err := bot.School.GetClassDetails(uri)
+// createNewEvent stores event, a new event with its first subscriber or channel,
+// along with the current details of its class
+func (bot *Bot) createNewEvent(event Event) (Event, error) {
+	school, ok := bot.Schools.Get(event.School)
+	if !ok {
+		return Event{}, fmt.Errorf("no school registered as %s", event.School)
+	}
+	details, err := school.GetClassDetails(event.URI)
 	if err != nil {
-		return Event{}, fmt.Errorf("getting class details of %s", err)
+		return Event{}, fmt.Errorf("getting class details: %w", err)
 	}
+	event.ClassDetails = details
 
-	event, err := bot.DB.CreateEvent(uri, details, userID)
+	event, err = bot.DB.CreateEvent(event)
 	if err != nil {
-		return Event{}, fmt.Errorf("creating new event with details %s: %s",
+		return Event{}, fmt.Errorf("creating new event with details %s: %w",
 			details, err)
 	}
-	log.Printf("created new event: %s\n", event)
+	slog.Info("created event", "event", event.URI, "school", event.School, "status", event.ClassDetails.Status)
+	bot.recordHistory(Change{Event: event, At: time.Now()})
 	return event, nil
 }
 
-func (bot *Bot) Unsubscribe(uri string, userID string) (Event, error) {
+// SubscribeChannel posts the alerts of the event of target to a server channel
+func (bot *Bot) SubscribeChannel(target string, defaultSchool string, channel ChannelTarget) (Event, error) {
+	schoolID, uri, err := bot.Schools.Resolve(target, defaultSchool)
+	if err != nil {
+		return Event{}, fmt.Errorf("resolving school of %s: %w", target, err)
+	}
 	event, err := bot.DB.GetEventWithURI(uri)
 	if err != nil {
-		return Event{}, fmt.Errorf("unable to get event with uri %s: %s", uri, err)
+		if errors.Is(err, ErrNoSuchEvent) {
+			event, err := bot.createNewEvent(Event{URI: uri, School: schoolID, Channels: []ChannelTarget{channel}})
+			if err != nil {
+				return Event{}, fmt.Errorf("creating new event with uri %s and channel %s: %w", uri, channel.ChannelID, err)
+			}
+			return event, nil
+		}
+		return Event{}, fmt.Errorf("getting event %s from database: %s", uri, err)
+	}
+	if err := bot.DB.AddChannel(uri, channel); err != nil {
+		return Event{}, fmt.Errorf("adding channel %s to event %s: %s", channel.ChannelID, uri, err)
+	}
+	slog.Info("added channel", "event", uri, "guild", channel.GuildID, "channel", channel.ChannelID)
+	return event, nil
+}
+
+func (bot *Bot) UnsubscribeChannel(target string, defaultSchool string, guildID string, channelID string) (Event, error) {
+	uri := target
+	if _, resolved, err := bot.Schools.Resolve(target, defaultSchool); err == nil {
+		uri = resolved
+	}
+	event, err := bot.DB.GetEventWithURI(uri)
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to get event with uri %s: %w", uri, err)
+	}
+	if err := bot.DB.RemoveChannel(uri, guildID, channelID); err != nil {
+		return Event{}, fmt.Errorf("unable to remove channel: %w", err)
+	}
+	slog.Info("removed channel", "event", uri, "guild", guildID, "channel", channelID)
+	return event, nil
+}
+
+func (bot *Bot) Unsubscribe(target string, defaultSchool string, userID string) (Event, error) {
+	uri := target
+	if _, resolved, err := bot.Schools.Resolve(target, defaultSchool); err == nil {
+		uri = resolved
+	}
+	event, err := bot.DB.GetEventWithURI(uri)
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to get event with uri %s: %w", uri, err)
 	}
 	if err := bot.DB.RemoveSubscriber(uri, userID); err != nil {
-		return Event{}, fmt.Errorf("unable to remove subscriber: %s", err)
+		return Event{}, fmt.Errorf("unable to remove subscriber: %w", err)
+	}
+	slog.Info("removed subscriber", "event", uri, userKey, userID)
+	return event, nil
+}
+
+// Status checks the class of target right away, whether or not monitoring is
+// paused. When the class is tracked, its stored details are updated and tracked is
+// true. A new status is left for the monitor to alert once it settled, so that
+// checks on request can neither settle a flapping status early nor race with the
+// monitor alerting it.
+func (bot *Bot) Status(target string, defaultSchool string) (event Event, tracked bool, err error) {
+	schoolID, uri, err := bot.Schools.Resolve(target, defaultSchool)
+	if err != nil {
+		return Event{}, false, fmt.Errorf("resolving school of %s: %w", target, err)
+	}
+	school, _ := bot.Schools.Get(schoolID)
+	event, err = bot.DB.GetEventWithURI(uri)
+	if err != nil && !errors.Is(err, ErrNoSuchEvent) {
+		return Event{}, false, fmt.Errorf("unable to get event with uri %s: %s", uri, err)
+	}
+	tracked = err == nil
+	event.URI = uri
+	event.School = schoolID
+
+	started := time.Now()
+	details, err := school.GetClassDetails(uri)
+	bot.Metrics.check(schoolID, 1, started, err)
+	if tracked && bot.Health != nil {
+		bot.Health.Record(schoolID, uri, err)
+	}
+	if err != nil {
+		return Event{}, tracked, fmt.Errorf("unable to get class details: %w", err)
+	}
+	if tracked {
+		slog.Debug("checked event on request", "school", schoolID, "event", uri, "status", details.Status)
+		// events never alerted take their stored details as the status subscribers
+		// know, which has to be kept before the details change
+		if event.Notified.Status == "" {
+			if err := bot.DB.UpdateEventNotification(uri, event.ClassDetails, event.Pending); err != nil {
+				return Event{}, tracked, fmt.Errorf("unable to store notified status of event: %s", err)
+			}
+		}
+		if err := bot.DB.UpdateEventDetails(uri, details); err != nil {
+			return Event{}, tracked, fmt.Errorf("unable to update event with details %s: %s", details, err)
+		}
+	}
+	event.ClassDetails = details
+	return event, tracked, nil
+}
+
+// ForceCheck checks the class of a tracked event right away and returns it with
+// the latest details
+func (bot *Bot) ForceCheck(target string, defaultSchool string) (Event, error) {
+	event, tracked, err := bot.Status(target, defaultSchool)
+	if err != nil {
+		return Event{}, err
+	}
+	if !tracked {
+		return Event{}, ErrNoSuchEvent
+	}
+	return event, nil
+}
+
+// RemoveEvent stops monitoring the class of target for every subscriber
+func (bot *Bot) RemoveEvent(target string, defaultSchool string) (Event, error) {
+	uri := target
+	if _, resolved, err := bot.Schools.Resolve(target, defaultSchool); err == nil {
+		uri = resolved
+	}
+	event, err := bot.DB.GetEventWithURI(uri)
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to get event with uri %s: %w", uri, err)
+	}
+	if err := bot.DB.RemoveEvent(uri); err != nil {
+		return Event{}, fmt.Errorf("unable to remove event: %s", err)
+	}
+	slog.Info("removed event", "event", uri)
+	return event, nil
+}
+
+// Recipients returns every user and channel subscribed to an active event
+func (bot *Bot) Recipients() ([]string, []ChannelTarget, error) {
+	users := make([]string, 0)
+	channels := make([]ChannelTarget, 0)
+	seenUsers := make(map[string]bool)
+	seenChannels := make(map[string]bool)
+	for _, id := range bot.Schools.IDs() {
+		events, err := bot.DB.GetAllActiveEvents(id, id == bot.Schools.Default)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get active events: %s", err)
+		}
+		for _, event := range events {
+			for _, user := range event.Subscribers {
+				if !seenUsers[user] {
+					seenUsers[user] = true
+					users = append(users, user)
+				}
+			}
+			for _, channel := range event.Channels {
+				if !seenChannels[channel.ChannelID] {
+					seenChannels[channel.ChannelID] = true
+					channels = append(channels, channel)
+				}
+			}
+		}
+	}
+	return users, channels, nil
+}
+
+// Event returns the tracked event of the class of target
+func (bot *Bot) Event(target string, defaultSchool string) (Event, error) {
+	_, uri, err := bot.Schools.Resolve(target, defaultSchool)
+	if err != nil {
+		return Event{}, fmt.Errorf("resolving school of %s: %w", target, err)
+	}
+	event, err := bot.DB.GetEventWithURI(uri)
+	if err != nil {
+		return Event{}, fmt.Errorf("unable to get event with uri %s: %w", uri, err)
 	}
-	log.Printf("removed user %s from event %s\n", userID, uri)
 	return event, nil
 }
 
 func (bot *Bot) GetUserEvents(userID string) ([]Event, error) {
 	events, err := bot.DB.GetEventsWithSubscriber(userID)
 	if err != nil {
-		return nil, fmt.Errorf("unalbe to get events: %s", err)
+		return nil, fmt.Errorf("unalbe to get events: %w", err)
 	}
 	return events, nil
 }
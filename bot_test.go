@@ -0,0 +1,114 @@
+package class_notify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+func TestDebounce(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are the details seen by each check, in order
+		statuses []schools.ClassDetails
+		// changes are the statuses alerted, briefly opened classes are prefixed by brief
+		changes []string
+	}{
+		{
+			name:     "settled status is alerted once",
+			statuses: []schools.ClassDetails{openDetails, openDetails, openDetails},
+			changes:  []string{string(schools.OPENED)},
+		},
+		{
+			name:     "seat open for a single check is summarised",
+			statuses: []schools.ClassDetails{openDetails, fullDetails},
+			changes:  []string{"brief " + string(schools.OPENED)},
+		},
+		{
+			name:     "other status that reverts is not alerted",
+			statuses: []schools.ClassDetails{{Name: "CS 1332", Status: schools.COMPLETED}, fullDetails},
+		},
+		{
+			name:     "flapping status settles after two checks in a row",
+			statuses: []schools.ClassDetails{openDetails, fullDetails, openDetails, openDetails},
+			changes:  []string{"brief " + string(schools.OPENED), string(schools.OPENED)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+			tb.discord.Bot.Debounce = Debounce{Checks: 2}
+			var changes []string
+			record := func(change Change) error {
+				if change.Brief != nil {
+					changes = append(changes, "brief "+string(change.Brief.Status))
+				} else {
+					changes = append(changes, string(change.Event.ClassDetails.Status))
+				}
+				return nil
+			}
+			for _, details := range tt.statuses {
+				tb.school.details[testClass] = details
+				if err := tb.discord.Bot.Monitor("TEST", record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(changes) != len(tt.changes) {
+				t.Fatalf("changes = %v, want %v", changes, tt.changes)
+			}
+			for i := range changes {
+				if changes[i] != tt.changes[i] {
+					t.Errorf("changes = %v, want %v", changes, tt.changes)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBatchStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		unlisted []string
+		batchErr error
+		singles  int
+		changes  int
+		failing  int
+	}{
+		{name: "every class listed", changes: 2},
+		{name: "class missing from the listing", unlisted: []string{otherClass}, singles: 1, changes: 2},
+		{name: "listing failing to parse", batchErr: &schools.ParseError{Err: errors.New("no rows")}, failing: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t,
+				Event{URI: testClass, School: "BATCH", Subscribers: []string{"user"}, ClassDetails: fullDetails},
+				Event{URI: otherClass, School: "BATCH", Subscribers: []string{"user"}, ClassDetails: openDetails})
+			school := &fakeBatchSchool{fakeSchool: tb.school, unlisted: make(map[string]bool), batchErr: tt.batchErr}
+			for _, uri := range tt.unlisted {
+				school.unlisted[uri] = true
+			}
+			bot := tb.discord.Bot
+			bot.Schools.Register("BATCH", school, 0)
+			bot.Health = schools.NewHealth(0, nil)
+			changes := 0
+			record := func(change Change) error {
+				changes++
+				return nil
+			}
+
+			if err := bot.Monitor("BATCH", record); err != nil {
+				t.Fatal(err)
+			}
+			if len(school.batches) != 1 || len(school.batches[0]) != 2 {
+				t.Errorf("batches = %v, want both classes in one listing", school.batches)
+			}
+			if len(school.singles) != tt.singles || changes != tt.changes {
+				t.Errorf("checked %v on their own with %d changes, want %d and %d", school.singles, changes, tt.singles, tt.changes)
+			}
+			if health := bot.Health.Snapshot(); len(health) != 1 || health[0].Checks != 2 || health[0].ParseFailures != tt.failing {
+				t.Errorf("health = %+v, want every class recorded once", health)
+			}
+		})
+	}
+}
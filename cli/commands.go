@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	class_notify "github.com/zMrKrabz/class-notify"
+	"github.com/zMrKrabz/class-notify/schools"
+	"log"
+	"os"
+	"time"
+)
+
+// commands syncs the slash commands of the bot with its manifest, or removes them
+func commands(args []string) {
+	fs := flag.NewFlagSet("commands", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CLASS_NOTIFY_CONFIG"), "yaml config file the token, schools and scrapers are read from when not given by flags")
+	token := fs.String("auth", "", "discord authentication token, which shows in process listings, prefer the config or its environment variables")
+	guild := fs.String("guild", "", "only manage the commands of this guild, every guild the bot is in when empty")
+	global := fs.Bool("global", false, "manage the global commands instead of guild commands")
+	school := fs.String("school", "", "comma separated schools to serve, the first one is the default, all known schools when empty")
+	scrapersDir := fs.String("scrapers", "", "directory of declarative scraper definitions")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: commands [flags] sync|purge")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || (fs.Arg(0) != "sync" && fs.Arg(0) != "purge") {
+		fs.Usage()
+		log.Fatal("expected sync or purge")
+	}
+
+	cfg := defaultConfig()
+	if err := cfg.load(*configPath, os.LookupEnv); err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Notifiers.Discord.Token.read(); err != nil {
+		log.Fatalf("reading discord token: %s", err)
+	}
+	if *token == "" {
+		*token = cfg.Notifiers.Discord.Token.Value
+	}
+	serve := cfg.Schools.Serve
+	if *school != "" {
+		serve = splitList(*school)
+	}
+	if *scrapersDir == "" {
+		*scrapersDir = cfg.Schools.Scrapers
+	}
+
+	// the manifest offers the served schools as choices
+	var scrapers []*schools.Scraper
+	if *scrapersDir != "" {
+		loaded, err := schools.LoadDefinitions(*scrapersDir)
+		if err != nil {
+			log.Fatalf("loading scraper definitions: %s", err)
+		}
+		scrapers = loaded
+	}
+	registry, err := buildRegistry(serve, nil, time.Minute, schools.DefaultFetcher, scrapers)
+	if err != nil {
+		log.Fatalf("setting up schools: %s", err)
+	}
+
+	dg := class_notify.Discord{Bot: &class_notify.Bot{Schools: registry}}
+	if err := dg.Login(*token); err != nil {
+		log.Fatalf("logging in to discord: %s", err)
+	}
+	guilds := []string{*guild}
+	if *global {
+		guilds = []string{""}
+	} else if *guild == "" {
+		if guilds, err = dg.Guilds(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, id := range guilds {
+		name := id
+		if name == "" {
+			name = "global"
+		}
+		if fs.Arg(0) == "purge" {
+			if err := dg.PurgeCommands(id); err != nil {
+				log.Fatalf("purging commands of %s: %s", name, err)
+			}
+			fmt.Printf("removed commands of %s\n", name)
+			continue
+		}
+		changed, err := dg.SyncCommands(id)
+		if err != nil {
+			log.Fatalf("syncing commands of %s: %s", name, err)
+		}
+		fmt.Printf("synced commands of %s, changed: %t\n", name, changed)
+	}
+}
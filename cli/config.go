@@ -0,0 +1,566 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the bot is configured with. It is read from a yaml file,
+// then from CLASS_NOTIFY_* environment variables and then from flags, each
+// overriding the previous ones.
+type Config struct {
+	Database  databaseConfig  `yaml:"database"`
+	Schools   schoolsConfig   `yaml:"schools"`
+	Polling   pollingConfig   `yaml:"polling"`
+	Fetch     fetchConfig     `yaml:"fetch"`
+	Notifiers notifiersConfig `yaml:"notifiers"`
+	HTTP      httpConfig      `yaml:"http"`
+	Logging   loggingConfig   `yaml:"logging"`
+}
+
+type databaseConfig struct {
+	URL Secret `yaml:"url"`
+}
+
+type schoolsConfig struct {
+	// Serve lists the schools served, the first one is the default, every known
+	// school when empty
+	Serve []string `yaml:"serve"`
+	// Scrapers is the directory of declarative scraper definitions
+	Scrapers string `yaml:"scrapers"`
+	// AlertThreshold is the number of events failing to parse in a row before
+	// alerting the admin channel
+	AlertThreshold int `yaml:"alert_threshold"`
+}
+
+type pollingConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// Schools overrides the interval of some schools
+	Schools        map[string]time.Duration `yaml:"schools"`
+	DebounceChecks int                      `yaml:"debounce_checks"`
+	DebounceFor    time.Duration            `yaml:"debounce_for"`
+}
+
+type fetchConfig struct {
+	Timeout   time.Duration `yaml:"timeout"`
+	Retries   int           `yaml:"retries"`
+	Backoff   time.Duration `yaml:"backoff"`
+	UserAgent string        `yaml:"user_agent"`
+	Proxy     string        `yaml:"proxy"`
+	Cookies   bool          `yaml:"cookies"`
+	// CacheTTL is how long fetched pages are reused, 0 disables caching
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+type notifiersConfig struct {
+	Discord discordConfig `yaml:"discord"`
+}
+
+type discordConfig struct {
+	Token Secret `yaml:"token"`
+	// Guild limits the commands to one guild, every guild the bot is in when empty
+	Guild        string        `yaml:"guild"`
+	AdminChannel string        `yaml:"admin_channel"`
+	Operators    []string      `yaml:"operators"`
+	RateLimit    time.Duration `yaml:"rate_limit"`
+	// StatusCooldown is the least time between two /status checks of a user
+	StatusCooldown time.Duration `yaml:"status_cooldown"`
+}
+
+type httpConfig struct {
+	// Addr is where the http server listens, it is disabled when empty
+	Addr       string        `yaml:"addr"`
+	PublicURL  string        `yaml:"public_url"`
+	StuckAfter time.Duration `yaml:"stuck_after"`
+	// APIKeys maps the name of applications to their key
+	APIKeys   map[string]Secret `yaml:"api_keys"`
+	Stream    bool              `yaml:"stream"`
+	Dashboard bool              `yaml:"dashboard"`
+}
+
+type loggingConfig struct {
+	Level       slog.Level `yaml:"level"`
+	JSON        bool       `yaml:"json"`
+	RedactUsers bool       `yaml:"redact_users"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Database: databaseConfig{URL: Secret{Value: "mongodb://127.0.0.1:27017"}},
+		Schools:  schoolsConfig{AlertThreshold: 5},
+		Polling:  pollingConfig{Interval: time.Minute, DebounceChecks: 2},
+		Fetch: fetchConfig{
+			Timeout:   30 * time.Second,
+			Retries:   2,
+			Backoff:   time.Second,
+			UserAgent: schools.DefaultUserAgent,
+			CacheTTL:  10 * time.Second,
+		},
+		Notifiers: notifiersConfig{Discord: discordConfig{RateLimit: 5 * time.Minute, StatusCooldown: 30 * time.Second}},
+		HTTP:      httpConfig{StuckAfter: 10 * time.Minute},
+		Logging:   loggingConfig{Level: slog.LevelInfo, RedactUsers: true},
+	}
+}
+
+// options returns the options of the fetcher of schools
+func (c fetchConfig) options() schools.FetcherOptions {
+	return schools.FetcherOptions{
+		Timeout:   c.Timeout,
+		Retries:   c.Retries,
+		Backoff:   c.Backoff,
+		UserAgent: c.UserAgent,
+		Proxy:     c.Proxy,
+		Cookies:   c.Cookies,
+	}
+}
+
+// Secret is a value kept out of process listings and, with File, out of the config
+// file, such as a docker or kubernetes secret. In yaml it is either the value or
+// {file: <path>}.
+type Secret struct {
+	Value string
+	File  string
+}
+
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = Secret{Value: node.Value}
+		return nil
+	}
+	var ref struct {
+		File string `yaml:"file"`
+	}
+	if err := node.Decode(&ref); err != nil || ref.File == "" {
+		return fmt.Errorf("line %d: a secret is either a value or {file: <path>}", node.Line)
+	}
+	*s = Secret{File: ref.File}
+	return nil
+}
+
+// MarshalYAML leaves the value out of printed configs
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s.File != "" {
+		return map[string]string{"file": s.File}, nil
+	}
+	return s.String(), nil
+}
+
+func (s *Secret) Set(value string) error {
+	*s = Secret{Value: value}
+	return nil
+}
+
+func (s *Secret) String() string {
+	if s == nil || s.Value == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+// read sets the value of s from its file
+func (s *Secret) read() error {
+	if s.File == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.File)
+	if err != nil {
+		return err
+	}
+	if s.Value = strings.TrimSpace(string(b)); s.Value == "" {
+		return fmt.Errorf("%s is empty", s.File)
+	}
+	return nil
+}
+
+// bindFlags registers flags setting the fields of c on fs, their defaults are the
+// current values of c
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.Var(&c.Database.URL, "mongo", "mongodb database url, mongodb://127.0.0.1:27017 by default")
+	fs.Func("school", "comma separated schools to serve, the first one is the default, all known schools when empty", func(list string) error {
+		c.Schools.Serve = splitList(list)
+		return nil
+	})
+	fs.StringVar(&c.Schools.Scrapers, "scrapers", c.Schools.Scrapers, "directory of declarative scraper definitions")
+	fs.IntVar(&c.Schools.AlertThreshold, "alert-threshold", c.Schools.AlertThreshold, "number of events failing to parse in a row before alerting")
+	fs.DurationVar(&c.Polling.Interval, "poll", c.Polling.Interval, "wait between two checks of every class")
+	fs.Func("school-poll", "comma separated poll intervals of specific schools, such as GEORGIA_TECH=30s", func(list string) error {
+		intervals, err := parseIntervals(list)
+		c.Polling.Schools = intervals
+		return err
+	})
+	fs.IntVar(&c.Polling.DebounceChecks, "debounce-checks", c.Polling.DebounceChecks, "number of checks in a row a new status must be seen before alerting it")
+	fs.DurationVar(&c.Polling.DebounceFor, "debounce-for", c.Polling.DebounceFor, "how long a new status must last before alerting it")
+	addFetcherFlags(fs, &c.Fetch)
+	fs.DurationVar(&c.Fetch.CacheTTL, "cache-ttl", c.Fetch.CacheTTL, "how long fetched pages are reused before revalidating, 0 disables caching")
+	fs.Var(&c.Notifiers.Discord.Token, "auth", "discord authentication token, which shows in process listings, prefer CLASS_NOTIFY_NOTIFIERS_DISCORD_TOKEN_FILE")
+	fs.StringVar(&c.Notifiers.Discord.Guild, "guild", c.Notifiers.Discord.Guild, "only register commands in this guild, every guild the bot is in when empty")
+	fs.StringVar(&c.Notifiers.Discord.AdminChannel, "admin-channel", c.Notifiers.Discord.AdminChannel, "channel id scraper alerts are posted to")
+	fs.Func("operators", "comma separated ids of users allowed to use /admin", func(list string) error {
+		c.Notifiers.Discord.Operators = splitList(list)
+		return nil
+	})
+	fs.DurationVar(&c.Notifiers.Discord.RateLimit, "alert-rate-limit", c.Notifiers.Discord.RateLimit, "least time between two alerts of a class to a user, 0 disables it")
+	fs.DurationVar(&c.Notifiers.Discord.StatusCooldown, "status-cooldown", c.Notifiers.Discord.StatusCooldown, "least time between two /status checks of a user, 0 disables it")
+	fs.StringVar(&c.HTTP.Addr, "http", c.HTTP.Addr, "address the http server serving /metrics, /healthz, /readyz, /feeds, /dashboard and /api listens on, such as :9090, disabled when empty")
+	fs.StringVar(&c.HTTP.PublicURL, "public-url", c.HTTP.PublicURL, "url users reach the -http server at, such as https://alerts.example.com, /classes links atom feeds when set")
+	fs.DurationVar(&c.HTTP.StuckAfter, "stuck-after", c.HTTP.StuckAfter, "how long past its poll interval a school may go without completing a check, or discord stay disconnected, before /healthz fails")
+	fs.Func("api-keys", "comma separated name=key api keys of applications allowed to use the http api, which is disabled when empty", func(list string) error {
+		keys, err := parsePairs(list)
+		c.HTTP.APIKeys = make(map[string]Secret)
+		for name, key := range keys {
+			c.HTTP.APIKeys[name] = Secret{Value: key}
+		}
+		return err
+	})
+	fs.BoolVar(&c.HTTP.Stream, "stream", c.HTTP.Stream, "stream status changes as server-sent events on /api/v1/stream, which needs no api key")
+	fs.BoolVar(&c.HTTP.Dashboard, "dashboard", c.HTTP.Dashboard, "serve the web dashboard on /dashboard/, users log in with links sent by /dashboard and are logged out on restart, needs -http and -public-url")
+	fs.TextVar(&c.Logging.Level, "log-level", c.Logging.Level, "least level of the logs written, one of debug, info, warn or error")
+	fs.BoolVar(&c.Logging.JSON, "log-json", c.Logging.JSON, "write logs as one json object per line")
+	fs.BoolVar(&c.Logging.RedactUsers, "log-redact-users", c.Logging.RedactUsers, "replace user ids in logs by a hash that is stable until restart")
+}
+
+// config checks the config the bot would run with, given the same file,
+// environment and flags, and prints it with its secrets left out
+func config(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: config check [flags]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args[1:], os.LookupEnv)
+	if err == nil {
+		err = errors.Join(cfg.Check(), cfg.checkSchools())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%s\n", err)
+		os.Exit(1)
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		log.Fatalf("printing config: %s", err)
+	}
+	fmt.Printf("%s\nconfig is valid\n", out)
+}
+
+// checkSchools loads the scraper definitions and checks that the schools served
+// and polled exist, without fetching anything
+func (c *Config) checkSchools() error {
+	var scrapers []*schools.Scraper
+	if c.Schools.Scrapers != "" {
+		loaded, err := schools.LoadDefinitions(c.Schools.Scrapers)
+		if err != nil {
+			return fmt.Errorf("schools.scrapers: %s", err)
+		}
+		scrapers = loaded
+	}
+	if _, err := buildRegistry(c.Schools.Serve, c.Polling.Schools, c.Polling.Interval, schools.DefaultFetcher, scrapers); err != nil {
+		return fmt.Errorf("schools: %s", err)
+	}
+	return nil
+}
+
+// parseConfig reads the config of the bot from the file given by -config, the
+// environment and the flags of args
+func parseConfig(fs *flag.FlagSet, args []string, lookup func(string) (string, bool)) (Config, error) {
+	c := defaultConfig()
+	path, _ := lookup("CLASS_NOTIFY_CONFIG")
+	fs.StringVar(&path, "config", path, "yaml config file, also given by CLASS_NOTIFY_CONFIG")
+	bindFlags(fs, &c)
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if err := c.load(path, lookup); err != nil {
+		return c, err
+	}
+	// the file and the environment overwrote the flags, which take precedence
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// load reads the config file at path, when it is not empty, and then the
+// environment over c
+func (c *Config) load(path string, lookup func(string) (string, bool)) error {
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return err
+		}
+	}
+	return applyEnv(reflect.ValueOf(c).Elem(), "CLASS_NOTIFY", lookup)
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %s", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	// a file without any setting is empty rather than invalid
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading config %s: %s", path, err)
+	}
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	secretType          = reflect.TypeOf(Secret{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// applyEnv sets the fields of v from the environment variables named after their
+// yaml path under prefix, such as CLASS_NOTIFY_HTTP_PUBLIC_URL for http.public_url.
+// Lists are separated by commas and maps written as name=value,name=value. Secrets
+// are also read from the file named by the variable with a _FILE suffix.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("yaml")
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Type() == secretType {
+			if value, ok := lookup(name); ok {
+				field.Set(reflect.ValueOf(Secret{Value: value}))
+			}
+			if file, ok := lookup(name + "_FILE"); ok {
+				field.Set(reflect.ValueOf(Secret{File: file}))
+			}
+			continue
+		}
+		if field.Kind() == reflect.Struct && !field.Addr().Type().Implements(textUnmarshalerType) {
+			if err := applyEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses value into field
+func setField(field reflect.Value, value string) error {
+	if field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s is not a number", value)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s is not true or false", value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.ValueOf(splitList(value)))
+	case field.Kind() == reflect.Map:
+		pairs, err := parsePairs(value)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMap(field.Type())
+		for k, v := range pairs {
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if elem.Type() == secretType {
+				elem.Set(reflect.ValueOf(Secret{Value: v}))
+			} else if err := setField(elem, v); err != nil {
+				return fmt.Errorf("%s: %s", k, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		field.Set(m)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// parsePairs reads comma separated entries such as name=value
+func parsePairs(list string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("entry %q should look like name=value", entry)
+		}
+		pairs[parts[0]] = parts[1]
+	}
+	return pairs, nil
+}
+
+// parseIntervals reads comma separated poll intervals such as GEORGIA_TECH=30s
+func parseIntervals(list string) (map[string]time.Duration, error) {
+	pairs, err := parsePairs(list)
+	if err != nil {
+		return nil, err
+	}
+	intervals := make(map[string]time.Duration)
+	for id, value := range pairs {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing poll interval of %s: %s", id, err)
+		}
+		intervals[id] = interval
+	}
+	return intervals, nil
+}
+
+// Check reads the secrets of c from their files and validates every setting,
+// returning all the problems found
+func (c *Config) Check() error {
+	var errs []error
+	read := func(field string, secret *Secret) {
+		if err := secret.read(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: reading secret: %s", field, err))
+		}
+	}
+	read("database.url", &c.Database.URL)
+	read("notifiers.discord.token", &c.Notifiers.Discord.Token)
+	for _, name := range sortedKeys(c.HTTP.APIKeys) {
+		key := c.HTTP.APIKeys[name]
+		read("http.api_keys."+name, &key)
+		c.HTTP.APIKeys[name] = key
+	}
+	return errors.Join(append(errs, c.validate()...)...)
+}
+
+// validate returns the problems of the settings of c, which secrets were read
+func (c *Config) validate() []error {
+	var errs []error
+	invalid := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	positive := map[string]time.Duration{
+		"polling.interval": c.Polling.Interval,
+		"fetch.timeout":    c.Fetch.Timeout,
+		"http.stuck_after": c.HTTP.StuckAfter,
+	}
+	for id, interval := range c.Polling.Schools {
+		positive["polling.schools."+id] = interval
+	}
+	for _, field := range sortedKeys(positive) {
+		if positive[field] <= 0 {
+			invalid(field, "should be a positive duration such as 30s, got %s", positive[field])
+		}
+	}
+	notNegative := map[string]time.Duration{
+		"polling.debounce_for":              c.Polling.DebounceFor,
+		"fetch.backoff":                     c.Fetch.Backoff,
+		"fetch.cache_ttl":                   c.Fetch.CacheTTL,
+		"notifiers.discord.rate_limit":      c.Notifiers.Discord.RateLimit,
+		"notifiers.discord.status_cooldown": c.Notifiers.Discord.StatusCooldown,
+	}
+	for _, field := range sortedKeys(notNegative) {
+		if notNegative[field] < 0 {
+			invalid(field, "cannot be negative, use 0 to turn it off")
+		}
+	}
+
+	if c.Notifiers.Discord.Token.Value == "" && c.Notifiers.Discord.Token.File == "" {
+		invalid("notifiers.discord.token", "is required, set it with {file: <path>} or CLASS_NOTIFY_NOTIFIERS_DISCORD_TOKEN_FILE")
+	}
+	if u, err := url.Parse(c.Database.URL.Value); c.Database.URL.Value != "" && (err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv")) {
+		invalid("database.url", "should be a mongodb:// or mongodb+srv:// url")
+	} else if c.Database.URL.Value == "" && c.Database.URL.File == "" {
+		invalid("database.url", "is required")
+	}
+	if c.Schools.AlertThreshold < 1 {
+		invalid("schools.alert_threshold", "should be at least 1, got %d", c.Schools.AlertThreshold)
+	}
+	if c.Polling.DebounceChecks < 1 {
+		invalid("polling.debounce_checks", "should be at least 1, got %d", c.Polling.DebounceChecks)
+	}
+	if c.Fetch.Retries < 0 {
+		invalid("fetch.retries", "cannot be negative")
+	}
+	if u, err := url.Parse(c.Fetch.Proxy); c.Fetch.Proxy != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		invalid("fetch.proxy", "should be a url such as http://proxy:3128")
+	}
+
+	if u, err := url.Parse(c.HTTP.PublicURL); c.HTTP.PublicURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		invalid("http.public_url", "should be an http or https url such as https://alerts.example.com")
+	}
+	if c.HTTP.Addr == "" {
+		if len(c.HTTP.APIKeys) > 0 {
+			invalid("http.api_keys", "needs http.addr")
+		}
+		if c.HTTP.Stream {
+			invalid("http.stream", "needs http.addr")
+		}
+		if c.HTTP.Dashboard {
+			invalid("http.dashboard", "needs http.addr")
+		}
+	}
+	if c.HTTP.Dashboard && c.HTTP.PublicURL == "" {
+		invalid("http.dashboard", "needs http.public_url, which login links point to")
+	}
+	owners := make(map[string]string)
+	for _, name := range sortedKeys(c.HTTP.APIKeys) {
+		key := c.HTTP.APIKeys[name]
+		if key.Value == "" {
+			if key.File == "" {
+				invalid("http.api_keys."+name, "is empty")
+			}
+			continue
+		}
+		if owner, ok := owners[key.Value]; ok {
+			invalid("http.api_keys."+name, "is the same key as the one of %s", owner)
+		}
+		owners[key.Value] = name
+	}
+	return errs
+}
+
+// apiKeys returns the names of the applications using the api by their key
+func (c *Config) apiKeys() map[string]string {
+	keys := make(map[string]string)
+	for name, key := range c.HTTP.APIKeys {
+		keys[key.Value] = name
+	}
+	return keys
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(filepath.Join(dir, "token"), []byte("secret-token\n"), 0o600)
+	os.WriteFile(path, []byte(`
+polling:
+  interval: 2m
+  schools: {GEORGIA_TECH: 30s}
+http:
+  addr: ":1"
+  api_keys:
+    planner: {file: `+filepath.Join(dir, "token")+`}
+`), 0o600)
+	env := map[string]string{
+		"CLASS_NOTIFY_CONFIG":                       path,
+		"CLASS_NOTIFY_POLLING_INTERVAL":             "3m",
+		"CLASS_NOTIFY_NOTIFIERS_DISCORD_TOKEN_FILE": filepath.Join(dir, "token"),
+		"CLASS_NOTIFY_NOTIFIERS_DISCORD_OPERATORS":  "a, b",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	cfg, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-http", ":2"}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Polling.Interval != 3*time.Minute || cfg.Polling.Schools["GEORGIA_TECH"] != 30*time.Second {
+		t.Errorf("polling = %+v, want the interval of the environment over the file", cfg.Polling)
+	}
+	if cfg.HTTP.Addr != ":2" {
+		t.Errorf("addr = %q, want the flag over the file", cfg.HTTP.Addr)
+	}
+	if cfg.Notifiers.Discord.Token.Value != "secret-token" || cfg.apiKeys()["secret-token"] != "planner" {
+		t.Errorf("secrets were not read from their files: %+v", cfg)
+	}
+	if ops := cfg.Notifiers.Discord.Operators; len(ops) != 2 || ops[1] != "b" {
+		t.Errorf("operators = %q", ops)
+	}
+	if cfg.Fetch.Timeout != 30*time.Second {
+		t.Errorf("fetch timeout = %s, want the default", cfg.Fetch.Timeout)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"unknown field", "polling:\n  intervl: 1m", "field intervl not found"},
+		{"missing token", "", "notifiers.discord.token: is required"},
+		{"negative interval", "polling:\n  interval: -1m", "polling.interval: should be a positive duration"},
+		{"database url", "database:\n  url: postgres://db", "database.url: should be a mongodb://"},
+		{"dashboard without url", "http:\n  addr: :1\n  dashboard: true", "http.dashboard: needs http.public_url"},
+		{"shared api key", "http:\n  addr: :1\n  api_keys: {a: key, b: key}", "http.api_keys.b: is the same key as the one of a"},
+		{"missing secret file", "notifiers:\n  discord:\n    token: {file: /nonexistent}", "notifiers.discord.token: reading secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			os.WriteFile(path, []byte(tt.yaml), 0o600)
+			lookup := func(string) (string, bool) { return "", false }
+			cfg, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path}, lookup)
+			if err == nil {
+				err = cfg.Check()
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+)
+
+// addFetcherFlags registers the options of the fetcher used by schools on fs,
+// their defaults are the current values of c
+func addFetcherFlags(fs *flag.FlagSet, c *fetchConfig) {
+	fs.DurationVar(&c.Timeout, "fetch-timeout", c.Timeout, "timeout of a single request to a school")
+	fs.IntVar(&c.Retries, "fetch-retries", c.Retries, "retries of a request to a school after a server error or timeout")
+	fs.DurationVar(&c.Backoff, "fetch-backoff", c.Backoff, "wait before the first retry, doubled on every retry")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "user agent sent to schools")
+	fs.StringVar(&c.Proxy, "proxy", c.Proxy, "proxy url requests to schools go through")
+	fs.BoolVar(&c.Cookies, "cookies", c.Cookies, "keep cookies set by schools between requests")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	class_notify "github.com/zMrKrabz/class-notify"
+	"log/slog"
+	"net/http"
+)
+
+// newMux routes the metrics of reg, the probes, the feeds and, when they are not
+// nil, the api and the dashboard
+func newMux(reg *prometheus.Registry, probes *class_notify.Probes, api *class_notify.API, feeds *class_notify.Feeds, dashboard *class_notify.Dashboard) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", probes.Healthz)
+	mux.HandleFunc("/readyz", probes.Readyz)
+	mux.Handle("/feeds/", feeds.Handler())
+	if api != nil {
+		mux.Handle("/api/", api.Handler())
+	}
+	if dashboard != nil {
+		mux.Handle("/dashboard/", dashboard.Handler())
+	}
+	return mux
+}
+
+func serveHTTP(addr string, handler http.Handler) {
+	slog.Info("serving http", "addr", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		slog.Error("http server stopped", "err", err)
+	}
+}
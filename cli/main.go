@@ -3,56 +3,142 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	class_notify "github.com/zMrKrabz/class-notify"
 	"github.com/zMrKrabz/class-notify/schools"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
-)
-
-var (
-	AUTH_TOKEN = ""
-	GUILD_ID = ""
-	MONGO_DB_URL = ""
-	SCHOOL = ""
+	"time"
 )
 
 func main() {
-	flag.StringVar(&AUTH_TOKEN,"auth", "", "discord authentication token")
-	flag.StringVar(&GUILD_ID, "guild", "", "guild id if specified")
-	flag.StringVar(&MONGO_DB_URL, "mongo", "mongodb://127.0.0.1:27017", "mongodb database url")
-	flag.StringVar(&SCHOOL, "school", "", "school to connect to")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			record(os.Args[2:])
+			return
+		case "commands":
+			commands(os.Args[2:])
+			return
+		case "config":
+			config(os.Args[2:])
+			return
+		}
+	}
+
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err == nil {
+		err = cfg.Check()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%s\n", err)
+		os.Exit(2)
+	}
+
+	slog.SetDefault(class_notify.NewLogger(os.Stderr, class_notify.LogOptions{
+		Level:       cfg.Logging.Level,
+		JSON:        cfg.Logging.JSON,
+		RedactUsers: cfg.Logging.RedactUsers,
+	}))
 
 	db := class_notify.Database{}
-	if err := db.Connect(MONGO_DB_URL); err != nil {
+	if err := db.Connect(cfg.Database.URL.Value); err != nil {
 		panic(fmt.Sprintf("error on connecting to mongodb database: %s", err))
 	}
 
-	var school schools.ISchool
-	switch SCHOOL {
-	case "GEORGIA_TECH":
-		school = &schools.GeorgiaTech{}
+	httpFetcher, err := schools.NewHTTPFetcher(cfg.Fetch.options())
+	if err != nil {
+		panic(fmt.Sprintf("error on creating school fetcher: %s", err))
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	var fetcher schools.Fetcher = httpFetcher
+	if cfg.Fetch.CacheTTL > 0 {
+		cache := schools.NewCachingFetcher(httpFetcher, cfg.Fetch.CacheTTL)
+		go logCacheStats(cache)
+		if err := class_notify.WatchCache(reg, cache); err != nil {
+			panic(fmt.Sprintf("error on registering cache metrics: %s", err))
+		}
+		fetcher = cache
+	}
+
+	var scrapers []*schools.Scraper
+	if cfg.Schools.Scrapers != "" {
+		loaded, err := schools.LoadDefinitions(cfg.Schools.Scrapers)
+		if err != nil {
+			panic(fmt.Sprintf("error on loading scraper definitions: %s", err))
+		}
+		scrapers = loaded
+		slog.Info("loaded scraper definitions", "definitions", len(scrapers), "dir", cfg.Schools.Scrapers)
+	}
+
+	registry, err := buildRegistry(cfg.Schools.Serve, cfg.Polling.Schools, cfg.Polling.Interval, fetcher, scrapers)
+	if err != nil {
+		panic(fmt.Sprintf("error on setting up schools: %s", err))
 	}
+	slog.Info("serving schools", "schools", registry.IDs(), "default", registry.Default)
 
 	bot := class_notify.Bot{
-		DB: &db,
-		School: school,
+		DB:       &db,
+		Schools:  registry,
+		Debounce: class_notify.Debounce{Checks: cfg.Polling.DebounceChecks, Duration: cfg.Polling.DebounceFor},
+	}
+	metrics, err := class_notify.NewMetrics(reg, &bot)
+	if err != nil {
+		panic(fmt.Sprintf("error on registering metrics: %s", err))
+	}
+	bot.Metrics = metrics
+	if cfg.HTTP.Stream {
+		bot.Stream = class_notify.NewStream()
 	}
 
 	dg := class_notify.Discord{
-		Bot: &bot,
+		Bot:            &bot,
+		AdminChannelID: cfg.Notifiers.Discord.AdminChannel,
+		Operators:      cfg.Notifiers.Discord.Operators,
+		RateLimit:      cfg.Notifiers.Discord.RateLimit,
+		StatusCooldown: cfg.Notifiers.Discord.StatusCooldown,
+		PublicURL:      cfg.HTTP.PublicURL,
 	}
-	if err := dg.Connect(AUTH_TOKEN, GUILD_ID); err != nil {
+	if cfg.HTTP.Dashboard {
+		dg.Dashboard = class_notify.NewDashboard(&bot, cfg.HTTP.PublicURL)
+	}
+	bot.Health = schools.NewHealth(cfg.Schools.AlertThreshold, func(alert schools.HealthAlert) {
+		slog.Warn("scraper health alert", "school", alert.School, "failing", alert.FailingEvents, "recovered", alert.Recovered)
+		if err := dg.AlertAdmin(alert); err != nil {
+			slog.Error("alerting admins failed", "err", err)
+		}
+	})
+	if err := dg.Connect(cfg.Notifiers.Discord.Token.Value, cfg.Notifiers.Discord.Guild); err != nil {
 		panic(fmt.Sprintf("unable to ocnnect to discord: %s", err))
 	}
 	defer dg.Close()
 
 	go bot.StartMonitor(dg.UpdateSubscriber)
+	go dg.StartQueue(time.Minute)
+	if cfg.HTTP.Addr != "" {
+		probes := &class_notify.Probes{Bot: &bot, Discord: &dg, StuckAfter: cfg.HTTP.StuckAfter}
+		var api *class_notify.API
+		if len(cfg.HTTP.APIKeys) > 0 || cfg.HTTP.Stream {
+			api = &class_notify.API{Bot: &bot, Keys: cfg.apiKeys()}
+		}
+		feeds := &class_notify.Feeds{Bot: &bot, PublicURL: cfg.HTTP.PublicURL, MaxAge: cfg.Polling.Interval}
+		go serveHTTP(cfg.HTTP.Addr, newMux(reg, probes, api, feeds, dg.Dashboard))
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
-	log.Println("Press CTRL + C to exit")
-	<- stop
-	log.Println("gracefully shutting down")
-}
\ No newline at end of file
+	slog.Info("running, press CTRL + C to exit")
+	<-stop
+	slog.Info("gracefully shutting down")
+}
+
+func logCacheStats(cache *schools.CachingFetcher) {
+	for range time.Tick(10 * time.Minute) {
+		stats := cache.Stats()
+		slog.Info("fetch cache", "hits", stats.Hits, "revalidated", stats.Revalidated, "misses", stats.Misses,
+			"coalesced", stats.Coalesced, "hit_rate", stats.HitRate())
+	}
+}
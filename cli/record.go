@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zMrKrabz/class-notify/schools"
+	"log"
+	"path/filepath"
+)
+
+// record saves a registrar page as a fixture for the schools tests
+func record(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	school := fs.String("school", "", "id of the school the page belongs to")
+	uri := fs.String("url", "", "url of the class to record")
+	name := fs.String("name", "", "name of the fixture")
+	dir := fs.String("dir", filepath.Join("schools", "testdata"), "directory holding the fixtures of every school")
+	scrapersDir := fs.String("scrapers", "", "directory of declarative scraper definitions")
+	fetchConfig := defaultConfig().Fetch
+	addFetcherFlags(fs, &fetchConfig)
+	fs.Parse(args)
+
+	if *school == "" || *uri == "" || *name == "" {
+		fs.Usage()
+		log.Fatal("-school, -url and -name are required")
+	}
+
+	// declarative scrapers may fetch a different url than the one subscribed to
+	target := *uri
+	if *scrapersDir != "" {
+		scrapers, err := schools.LoadDefinitions(*scrapersDir)
+		if err != nil {
+			log.Fatalf("loading scraper definitions: %s", err)
+		}
+		for _, s := range scrapers {
+			if s.Definition.ID != *school {
+				continue
+			}
+			if target, err = s.URL(*uri); err != nil {
+				log.Fatalf("building url of %s: %s", *uri, err)
+			}
+		}
+	}
+
+	fetcher, err := schools.NewHTTPFetcher(fetchConfig.options())
+	if err != nil {
+		log.Fatalf("creating fetcher: %s", err)
+	}
+	fixtureDir := filepath.Join(*dir, *school)
+	fixture, err := schools.RecordFixture(fetcher, fixtureDir, *name, *uri, target)
+	if err != nil {
+		log.Fatalf("recording fixture: %s", err)
+	}
+	fmt.Printf("saved %s to %s\n", fixture.Body, fixtureDir)
+	fmt.Println("fill in the expected details with: go test ./schools -run TestFixtures -update")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zMrKrabz/class-notify/schools"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// buildRegistry registers the schools of ids, or every known school when ids is
+// empty. polls overrides the poll interval of some schools. Known schools matching
+// the classes of a school registered before them are left out, while asking for
+// both is an error.
+func buildRegistry(ids []string, polls map[string]time.Duration, defaultPoll time.Duration,
+	fetcher schools.Fetcher, scrapers []*schools.Scraper) (*schools.Registry, error) {
+	available := map[string]schools.ISchool{
+		"GEORGIA_TECH": &schools.GeorgiaTech{Fetcher: fetcher},
+	}
+	order := []string{"GEORGIA_TECH"}
+	for _, s := range scrapers {
+		if _, ok := available[s.Definition.ID]; ok {
+			return nil, fmt.Errorf("scraper definition %s conflicts with a built in school", s.Definition.ID)
+		}
+		s.Fetcher = fetcher
+		available[s.Definition.ID] = s
+		order = append(order, s.Definition.ID)
+	}
+
+	intervals := make(map[string]time.Duration)
+	for id, interval := range polls {
+		intervals[id] = interval
+	}
+	every := len(ids) == 0
+	if every {
+		ids = order
+	}
+	registry := schools.NewRegistry()
+	for _, id := range ids {
+		school, ok := available[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown school %s, expected one of %s", id, strings.Join(order, ", "))
+		}
+		interval, ok := intervals[id]
+		if !ok {
+			interval = defaultPoll
+		}
+		delete(intervals, id)
+		if err := registry.Register(id, school, interval); err != nil {
+			if every && errors.Is(err, schools.ErrAmbiguousSchool) {
+				slog.Warn("leaving out school", "school", id, "err", err)
+				continue
+			}
+			return nil, err
+		}
+	}
+	for id := range intervals {
+		return nil, fmt.Errorf("poll interval given for %s, which is not served", id)
+	}
+	return registry, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
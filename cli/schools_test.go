@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+func TestBuildRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		want    string
+		wantErr error
+	}{
+		{"every school", nil, "[GEORGIA_TECH GEORGIA_TECH_BANNER]", nil},
+		{"definition alone", []string{"GEORGIA_TECH_OSCAR"}, "[GEORGIA_TECH_OSCAR]", nil},
+		{"schools claiming the same urls", []string{"GEORGIA_TECH_OSCAR", "GEORGIA_TECH"}, "", schools.ErrAmbiguousSchool},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scrapers, err := schools.LoadDefinitions(filepath.Join("..", "scrapers"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry, err := buildRegistry(tt.ids, nil, time.Minute, nil, scrapers)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(registry.IDs()); got != tt.want {
+				t.Errorf("schools = %s, want %s", got, tt.want)
+			}
+			oscar := "https://oscar.gatech.edu/bprod/bwckschd.p_disp_detail_sched?term_in=202208&crn_in=80123"
+			if id, _, err := registry.Resolve(oscar, ""); err != nil || id != registry.IDs()[0] {
+				t.Errorf("oscar class resolved to %s %v", id, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package class_notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"log/slog"
+	"reflect"
+	"sort"
+)
+
+// Login creates a discord session for token and looks up the bot's application,
+// without connecting to the gateway. It is enough to manage commands.
+func (d *Discord) Login(token string) error {
+	s, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return fmt.Errorf("connecting to discord: %s", err)
+	}
+	me, err := s.User("@me")
+	if err != nil {
+		return fmt.Errorf("looking up bot user: %s", err)
+	}
+	d.conn = s
+	d.session = s
+	d.appID = me.ID
+	return nil
+}
+
+// syncGuild syncs the commands of guildID once per connection
+func (d *Discord) syncGuild(guildID string) {
+	d.commandsMu.Lock()
+	defer d.commandsMu.Unlock()
+	if d.syncedGuilds[guildID] {
+		return
+	}
+	if _, err := d.SyncCommands(guildID); err != nil {
+		slog.Error("syncing commands failed", "guild", guildID, "err", err)
+		return
+	}
+	d.syncedGuilds[guildID] = true
+}
+
+// SyncCommands makes the commands of guildID, or the global commands when it is
+// empty, match the manifest. Commands are only overwritten when they differ, which
+// it reports.
+func (d *Discord) SyncCommands(guildID string) (bool, error) {
+	existing, err := d.session.ApplicationCommands(d.appID, guildID)
+	if err != nil {
+		return false, fmt.Errorf("listing commands: %s", err)
+	}
+	manifest := d.commands()
+	if sameCommands(existing, manifest) {
+		slog.Debug("commands are up to date", "guild", guildID)
+		return false, nil
+	}
+	if _, err := d.session.ApplicationCommandBulkOverwrite(d.appID, guildID, manifest); err != nil {
+		return false, fmt.Errorf("overwriting commands: %s", err)
+	}
+	slog.Info("overwrote commands", "guild", guildID, "commands", len(manifest))
+	return true, nil
+}
+
+// PurgeCommands removes every command of guildID, or the global commands when it
+// is empty
+func (d *Discord) PurgeCommands(guildID string) error {
+	if _, err := d.session.ApplicationCommandBulkOverwrite(d.appID, guildID, []*discordgo.ApplicationCommand{}); err != nil {
+		return fmt.Errorf("removing commands: %s", err)
+	}
+	slog.Info("removed commands", "guild", guildID)
+	return nil
+}
+
+// Guilds returns the ids of every guild the bot is in
+func (d *Discord) Guilds() ([]string, error) {
+	var ids []string
+	after := ""
+	for {
+		guilds, err := d.session.UserGuilds(100, "", after)
+		if err != nil {
+			return nil, fmt.Errorf("listing guilds: %s", err)
+		}
+		for _, g := range guilds {
+			ids = append(ids, g.ID)
+		}
+		if len(guilds) < 100 {
+			return ids, nil
+		}
+		after = guilds[len(guilds)-1].ID
+	}
+}
+
+// sameCommands reports whether existing, as returned by discord, declares the same
+// commands as manifest
+func sameCommands(existing []*discordgo.ApplicationCommand, manifest []*discordgo.ApplicationCommand) bool {
+	if len(existing) != len(manifest) {
+		return false
+	}
+	a, err := normalizeCommands(existing)
+	if err != nil {
+		return false
+	}
+	b, err := normalizeCommands(manifest)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// normalizeCommands turns commands into comparable values sorted by name, leaving
+// out what discord assigns, such as ids, and empty values discord may leave out
+func normalizeCommands(commands []*discordgo.ApplicationCommand) ([]interface{}, error) {
+	normalized := make([]interface{}, 0, len(commands))
+	for _, c := range commands {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		for _, key := range []string{"id", "application_id", "guild_id", "version", "default_permission"} {
+			delete(m, key)
+		}
+		if _, ok := m["type"]; !ok {
+			m["type"] = float64(discordgo.ChatApplicationCommand)
+		}
+		normalized = append(normalized, dropEmpty(m))
+	}
+	sort.Slice(normalized, func(i, j int) bool {
+		return fmt.Sprint(normalized[i].(map[string]interface{})["name"]) <
+			fmt.Sprint(normalized[j].(map[string]interface{})["name"])
+	})
+	return normalized, nil
+}
+
+// dropEmpty removes false, zero, empty and null values from decoded json
+func dropEmpty(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			value = dropEmpty(value)
+			if isEmpty(value) {
+				delete(v, key)
+				continue
+			}
+			v[key] = value
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = dropEmpty(value)
+		}
+		return v
+	}
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
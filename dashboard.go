@@ -0,0 +1,452 @@
+package class_notify
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+//go:embed dashboard.html
+var dashboardPage string
+
+var dashboardTemplates = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"statusColor": statusColor,
+}).Parse(dashboardPage))
+
+// Dashboard serves a web page where users manage their subscriptions and
+// preferences. Users log in with a one-time link the bot sends them by DM.
+// Login links and sessions are only kept in memory, a restart logs every user
+// out and they log in again with /dashboard.
+type Dashboard struct {
+	Bot *Bot
+	// PublicURL is where users reach the http server, login links point to it
+	PublicURL string
+	// LoginTTL is how long a login link can be used, SessionTTL how long a user
+	// stays logged in
+	LoginTTL   time.Duration
+	SessionTTL time.Duration
+
+	mu sync.Mutex
+	// logins and sessions map the secret of a login link or session cookie to the
+	// user it is for
+	logins   map[string]dashboardGrant
+	sessions map[string]dashboardGrant
+}
+
+type dashboardGrant struct {
+	userID  string
+	expires time.Time
+	// csrf is sent back by the forms of a session
+	csrf string
+	// flash is shown once on the next page of a session
+	flash string
+}
+
+const (
+	sessionCookie = "class_notify_session"
+	// sparklineLength is how many status changes a sparkline shows
+	sparklineLength = 20
+)
+
+func NewDashboard(bot *Bot, publicURL string) *Dashboard {
+	return &Dashboard{
+		Bot:        bot,
+		PublicURL:  strings.TrimSuffix(publicURL, "/"),
+		LoginTTL:   15 * time.Minute,
+		SessionTTL: 24 * time.Hour,
+		logins:     make(map[string]dashboardGrant),
+		sessions:   make(map[string]dashboardGrant),
+	}
+}
+
+// LoginURL returns a link logging userID in once
+func (d *Dashboard) LoginURL(userID string, now time.Time) (string, error) {
+	token, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prune(d.logins, now)
+	d.logins[token] = dashboardGrant{userID: userID, expires: now.Add(d.LoginTTL)}
+	return d.PublicURL + "/dashboard/login?token=" + token, nil
+}
+
+// redeem returns a session for the user of a login token, which cannot be used again
+func (d *Dashboard) redeem(token string, now time.Time) (string, dashboardGrant, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	login, ok := d.logins[token]
+	delete(d.logins, token)
+	if !ok || now.After(login.expires) {
+		return "", dashboardGrant{}, false
+	}
+	id, err := newSecret()
+	if err != nil {
+		return "", dashboardGrant{}, false
+	}
+	csrf, err := newSecret()
+	if err != nil {
+		return "", dashboardGrant{}, false
+	}
+	prune(d.sessions, now)
+	session := dashboardGrant{userID: login.userID, expires: now.Add(d.SessionTTL), csrf: csrf}
+	d.sessions[id] = session
+	return id, session, true
+}
+
+// setFlash shows message on the next page of the session of r
+func (d *Dashboard) setFlash(r *http.Request, message string) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if session, ok := d.sessions[cookie.Value]; ok {
+		session.flash = message
+		d.sessions[cookie.Value] = session
+	}
+}
+
+// takeFlash returns the message to show once in the session of r
+func (d *Dashboard) takeFlash(r *http.Request) string {
+	message := ""
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if session, ok := d.sessions[cookie.Value]; ok {
+			message, session.flash = session.flash, ""
+			d.sessions[cookie.Value] = session
+		}
+	}
+	return message
+}
+
+// session returns the session of the cookie of r
+func (d *Dashboard) session(r *http.Request, now time.Time) (string, dashboardGrant, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", dashboardGrant{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	session, ok := d.sessions[cookie.Value]
+	if !ok || now.After(session.expires) {
+		return "", dashboardGrant{}, false
+	}
+	return cookie.Value, session, true
+}
+
+func prune(grants map[string]dashboardGrant, now time.Time) {
+	for secret, grant := range grants {
+		if now.After(grant.expires) {
+			delete(grants, secret)
+		}
+	}
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating secret: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Handler routes the pages of the dashboard under /dashboard/
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dashboard/login", d.loginPage)
+	mux.HandleFunc("POST /dashboard/login", d.login)
+	mux.Handle("GET /dashboard/{$}", d.authenticate(d.home))
+	mux.Handle("POST /dashboard/unsubscribe", d.authenticate(d.unsubscribe))
+	mux.Handle("POST /dashboard/rules", d.authenticate(d.rules))
+	mux.Handle("POST /dashboard/preferences", d.authenticate(d.preferences))
+	mux.Handle("POST /dashboard/logout", d.authenticate(d.logout))
+	return mux
+}
+
+// dashboardHandler handles a request of a logged in user
+type dashboardHandler func(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger)
+
+// authenticate only lets requests of logged in users through, checking that forms
+// come from the dashboard
+func (d *Dashboard) authenticate(h dashboardHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		_, session, ok := d.session(r, time.Now())
+		if !ok {
+			d.render(w, http.StatusUnauthorized, dashboardView{Message: "You are not logged in, use /dashboard in Discord to get a login link."})
+			return
+		}
+		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+			if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(session.csrf)) != 1 {
+				http.Error(w, "this form has expired, reload the dashboard", http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r, session, slog.With("dashboard", r.URL.Path, userKey, session.userID))
+	})
+}
+
+// loginPage asks to confirm the login, so that link previews fetching the link do
+// not use it up
+func (d *Dashboard) loginPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	d.render(w, http.StatusOK, dashboardView{LoginToken: r.URL.Query().Get("token")})
+}
+
+func (d *Dashboard) login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	id, session, ok := d.redeem(r.PostFormValue("token"), time.Now())
+	if !ok {
+		d.render(w, http.StatusUnauthorized, dashboardView{Message: "This login link has expired or was already used, use /dashboard in Discord to get a new one."})
+		return
+	}
+	slog.Info("logged in to the dashboard", userKey, session.userID)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/dashboard/",
+		Expires:  session.expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(d.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/dashboard/", http.StatusSeeOther)
+}
+
+func (d *Dashboard) logout(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger) {
+	if id, _, ok := d.session(r, time.Now()); ok {
+		d.mu.Lock()
+		delete(d.sessions, id)
+		d.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/dashboard/", MaxAge: -1})
+	d.render(w, http.StatusOK, dashboardView{Message: "You are logged out."})
+}
+
+// dashboardView is what the dashboard template shows
+type dashboardView struct {
+	Message    string
+	LoginToken string
+	CSRF       string
+	Flash      string
+	Classes    []dashboardClass
+	// Preferences is nil on pages of users who are not logged in
+	Preferences *Preferences
+	Statuses    []schools.ClassStatus
+}
+
+type dashboardClass struct {
+	Event     Event
+	Sparkline []sparkBar
+	Statuses  map[schools.ClassStatus]bool
+	Immediate bool
+}
+
+// sparkBar is a status of a class in its sparkline, drawn at X
+type sparkBar struct {
+	X      int
+	Status schools.ClassStatus
+	Title  string
+}
+
+// alertStatuses are the statuses rules can pick
+var alertStatuses = []schools.ClassStatus{schools.OPENED, schools.WAITLISTED, schools.FULL, schools.COMPLETED}
+
+func (d *Dashboard) home(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger) {
+	events, err := d.Bot.GetUserEvents(session.userID)
+	if err != nil {
+		logger.Warn("listing classes failed", "err", err)
+		d.render(w, http.StatusInternalServerError, dashboardView{Message: "Unable to list your classes: " + explain(err)})
+		return
+	}
+	preferences, err := d.Bot.DB.GetPreferences(session.userID)
+	if err != nil {
+		logger.Warn("getting preferences failed", "err", err)
+		d.render(w, http.StatusInternalServerError, dashboardView{Message: "Unable to get your preferences."})
+		return
+	}
+	view := dashboardView{
+		CSRF:        session.csrf,
+		Flash:       d.takeFlash(r),
+		Preferences: &preferences,
+		Statuses:    alertStatuses,
+	}
+	for _, event := range events {
+		class := dashboardClass{
+			Event:     event,
+			Statuses:  make(map[schools.ClassStatus]bool),
+			Immediate: contains(preferences.Immediate, event.URI),
+		}
+		for _, status := range preferences.Statuses(event.URI) {
+			class.Statuses[status] = true
+		}
+		entries, err := d.Bot.DB.GetHistory(HistoryFilter{URI: event.URI, Limit: sparklineLength})
+		if err != nil {
+			logger.Warn("getting history failed", "event", event.URI, "err", err)
+		}
+		for i, entry := range entries {
+			class.Sparkline = append(class.Sparkline, sparkBar{
+				X:      i * 6,
+				Status: entry.Status,
+				Title:  fmt.Sprintf("%s %s", entry.Status, entry.At.In(preferences.Location()).Format("Jan 2 15:04")),
+			})
+		}
+		view.Classes = append(view.Classes, class)
+	}
+	d.render(w, http.StatusOK, view)
+}
+
+func (d *Dashboard) unsubscribe(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger) {
+	uris := r.PostForm["uri"]
+	failed := 0
+	for _, uri := range uris {
+		if _, err := d.Bot.Unsubscribe(uri, "", session.userID); err != nil {
+			logger.Warn("unsubscribing failed", "event", uri, "err", err)
+			failed++
+			continue
+		}
+		logger.Info("unsubscribed from the dashboard", "event", uri)
+	}
+	done := fmt.Sprintf("Unsubscribed from %d classes.", len(uris)-failed)
+	if failed > 0 {
+		done += fmt.Sprintf(" %d could not be removed, try again later.", failed)
+	}
+	d.redirectHome(w, r, done)
+}
+
+func (d *Dashboard) rules(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger) {
+	uri := r.PostFormValue("uri")
+	events, err := d.Bot.GetUserEvents(session.userID)
+	if err != nil || !subscribedTo(events, uri) {
+		http.Error(w, "you are not subscribed to this class", http.StatusNotFound)
+		return
+	}
+	var statuses []schools.ClassStatus
+	for _, status := range alertStatuses {
+		if contains(r.PostForm["status"], string(status)) {
+			statuses = append(statuses, status)
+		}
+	}
+	d.updatePreferences(w, r, session, logger, func(preferences *Preferences) string {
+		preferences.SetRule(uri, statuses)
+		immediate := make([]string, 0, len(preferences.Immediate)+1)
+		for _, u := range preferences.Immediate {
+			if u != uri {
+				immediate = append(immediate, u)
+			}
+		}
+		if r.PostFormValue("immediate") != "" {
+			immediate = append(immediate, uri)
+		}
+		preferences.Immediate = immediate
+		return ""
+	})
+}
+
+func (d *Dashboard) preferences(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger) {
+	d.updatePreferences(w, r, session, logger, func(preferences *Preferences) string {
+		zone := strings.TrimSpace(r.PostFormValue("timezone"))
+		if _, err := time.LoadLocation(zone); err != nil || zone == "Local" {
+			return fmt.Sprintf("%s is not a timezone, use a name such as America/New_York", zone)
+		}
+		preferences.Timezone = zone
+		start, end := r.PostFormValue("quiet_start"), r.PostFormValue("quiet_end")
+		if start == "" && end == "" {
+			preferences.QuietStart, preferences.QuietEnd = "", ""
+		} else {
+			for _, clock := range []string{start, end} {
+				if _, err := parseClock(clock); err != nil {
+					return err.Error()
+				}
+			}
+			preferences.QuietStart, preferences.QuietEnd = start, end
+		}
+		switch digest := r.PostFormValue("digest"); digest {
+		case "", DigestHourly, DigestDaily:
+			preferences.Digest = digest
+		default:
+			return fmt.Sprintf("%s is not a digest frequency", digest)
+		}
+		if at := r.PostFormValue("digest_time"); at != "" {
+			if _, err := parseClock(at); err != nil {
+				return err.Error()
+			}
+			preferences.DigestTime = at
+		}
+		return ""
+	})
+}
+
+// updatePreferences saves the preferences of the user once changed by update,
+// which returns why they could not be changed
+func (d *Dashboard) updatePreferences(w http.ResponseWriter, r *http.Request, session dashboardGrant, logger *slog.Logger, update func(*Preferences) string) {
+	preferences, err := d.Bot.DB.GetPreferences(session.userID)
+	if err != nil {
+		logger.Warn("getting preferences failed", "err", err)
+		d.redirectHome(w, r, "Unable to get your preferences, try again later.")
+		return
+	}
+	if invalid := update(&preferences); invalid != "" {
+		d.redirectHome(w, r, invalid)
+		return
+	}
+	if err := d.Bot.DB.SavePreferences(preferences); err != nil {
+		logger.Warn("saving preferences failed", "err", err)
+		d.redirectHome(w, r, "Unable to save your preferences, try again later.")
+		return
+	}
+	d.redirectHome(w, r, "Saved.")
+}
+
+func subscribedTo(events []Event, uri string) bool {
+	for _, event := range events {
+		if event.URI == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectHome sends the user back to the dashboard, showing done
+func (d *Dashboard) redirectHome(w http.ResponseWriter, r *http.Request, done string) {
+	d.setFlash(r, done)
+	http.Redirect(w, r, "/dashboard/", http.StatusSeeOther)
+}
+
+func (d *Dashboard) render(w http.ResponseWriter, status int, view dashboardView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := dashboardTemplates.Execute(w, view); err != nil {
+		slog.Warn("rendering dashboard failed", "err", err)
+	}
+}
+
+// statusColor returns the color a status is drawn in
+func statusColor(status schools.ClassStatus) string {
+	switch status {
+	case schools.OPENED:
+		return "#2e9e44"
+	case schools.WAITLISTED:
+		return "#d9a400"
+	case schools.FULL:
+		return "#c8322f"
+	}
+	return "#888888"
+}
@@ -0,0 +1,164 @@
+package class_notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// dashboardClient logs user in to a dashboard through /dashboard and returns a
+// client keeping its session
+func dashboardClient(t *testing.T, tb *testBot, user string) (*http.Client, string) {
+	t.Helper()
+	tb.discord.handleInteraction(command("dashboard", inDM(user)))
+	dms := tb.session.messages["dm-"+user]
+	if len(dms) == 0 || dms[len(dms)-1].Embed == nil {
+		t.Fatalf("no login link was sent, reply = %q", tb.session.reply())
+	}
+	link := dms[len(dms)-1].Embed.URL
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	status, page := dashboardRequest(t, client, "GET", link, nil)
+	if status != http.StatusOK || !strings.Contains(page, "Log in") {
+		t.Fatalf("login page = %d %s", status, page)
+	}
+	token := strings.TrimPrefix(link[strings.Index(link, "?"):], "?token=")
+	status, page = dashboardRequest(t, client, "POST", link, url.Values{"token": {token}})
+	if status != http.StatusOK {
+		t.Fatalf("login = %d %s", status, page)
+	}
+	csrf := regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`).FindStringSubmatch(page)
+	if csrf == nil {
+		t.Fatalf("dashboard = %s, want forms", page)
+	}
+	return client, csrf[1]
+}
+
+func dashboardRequest(t *testing.T, client *http.Client, method string, u string, form url.Values) (int, string) {
+	t.Helper()
+	var resp *http.Response
+	var err error
+	if method == "POST" {
+		resp, err = client.PostForm(u, form)
+	} else {
+		resp, err = client.Get(u)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func newTestDashboard(t *testing.T, events ...Event) (*testBot, string) {
+	t.Helper()
+	tb := newTestBot(t, events...)
+	server := httptest.NewUnstartedServer(nil)
+	tb.discord.Dashboard = NewDashboard(tb.discord.Bot, "http://"+server.Listener.Addr().String())
+	server.Config.Handler = tb.discord.Dashboard.Handler()
+	server.Start()
+	t.Cleanup(server.Close)
+	return tb, server.URL
+}
+
+func TestDashboardLogin(t *testing.T) {
+	tb, base := newTestDashboard(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: openDetails})
+	tb.store.AddHistory(HistoryEntry{URI: testClass, Status: schools.FULL, At: time.Now()})
+	tb.store.AddHistory(HistoryEntry{URI: testClass, Status: schools.OPENED, At: time.Now()})
+
+	client, _ := dashboardClient(t, tb, "user")
+	status, page := dashboardRequest(t, client, "GET", base+"/dashboard/", nil)
+	if status != http.StatusOK || !strings.Contains(page, "CS 1332") || strings.Count(page, "<rect") != 2 {
+		t.Errorf("dashboard = %d %s, want the class with a sparkline of its 2 changes", status, page)
+	}
+
+	// login links only work once
+	link := tb.session.messages["dm-user"][0].Embed.URL
+	token := link[strings.Index(link, "token=")+len("token="):]
+	if status, _ := dashboardRequest(t, &http.Client{}, "POST", base+"/dashboard/login", url.Values{"token": {token}}); status != http.StatusUnauthorized {
+		t.Errorf("used login link = %d, want 401", status)
+	}
+	if status, _ := dashboardRequest(t, &http.Client{}, "GET", base+"/dashboard/", nil); status != http.StatusUnauthorized {
+		t.Errorf("dashboard without a session = %d, want 401", status)
+	}
+
+	dashboardRequest(t, client, "POST", base+"/dashboard/logout", url.Values{"csrf": {"wrong"}})
+	if status, _ := dashboardRequest(t, client, "GET", base+"/dashboard/", nil); status != http.StatusOK {
+		t.Error("logging out without the csrf token of the session succeeded")
+	}
+}
+
+func TestDashboardForms(t *testing.T) {
+	tb, base := newTestDashboard(t,
+		Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: openDetails},
+		Event{URI: otherClass, School: "TEST", Subscribers: []string{"user", "other"}, ClassDetails: fullDetails},
+		Event{URI: testClass + "1", School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+	client, csrf := dashboardClient(t, tb, "user")
+
+	status, page := dashboardRequest(t, client, "POST", base+"/dashboard/rules", url.Values{
+		"csrf": {csrf}, "uri": {testClass}, "status": {"OPENED", "WAITLISTED"}, "immediate": {"on"},
+	})
+	preferences := tb.store.preferences["user"]
+	if status != http.StatusOK || !strings.Contains(page, "Saved.") {
+		t.Errorf("saving rules = %d %s", status, page)
+	}
+	if got := preferences.Statuses(testClass); len(got) != 2 || !contains(preferences.Immediate, testClass) {
+		t.Errorf("preferences = %+v, want the rules of the class", preferences)
+	}
+
+	_, page = dashboardRequest(t, client, "POST", base+"/dashboard/preferences", url.Values{
+		"csrf": {csrf}, "timezone": {"Mars/Olympus"}, "digest": {"daily"},
+	})
+	if !strings.Contains(page, "not a timezone") || tb.store.preferences["user"].Digest != "" {
+		t.Errorf("invalid preferences were saved, page = %s", page)
+	}
+	dashboardRequest(t, client, "POST", base+"/dashboard/preferences", url.Values{
+		"csrf": {csrf}, "timezone": {"America/Chicago"}, "quiet_start": {"23:00"}, "quiet_end": {"07:00"}, "digest": {"daily"}, "digest_time": {"08:00"},
+	})
+	preferences = tb.store.preferences["user"]
+	if preferences.Timezone != "America/Chicago" || !preferences.HasQuietHours() || preferences.Digest != DigestDaily || len(preferences.Rules) != 1 {
+		t.Errorf("preferences = %+v, want the form saved with the rules kept", preferences)
+	}
+
+	if status, _ := dashboardRequest(t, client, "POST", base+"/dashboard/unsubscribe", url.Values{"uri": {testClass}}); status != http.StatusForbidden {
+		t.Errorf("form without csrf token = %d, want 403", status)
+	}
+	_, page = dashboardRequest(t, client, "POST", base+"/dashboard/unsubscribe", url.Values{"csrf": {csrf}, "uri": {testClass, otherClass}})
+	events, _ := tb.store.GetEventsWithSubscriber("user")
+	if len(events) != 1 || !strings.Contains(page, "Unsubscribed from 2 classes") {
+		t.Errorf("subscriptions = %+v after bulk unsubscribe, page = %s", events, page)
+	}
+}
+
+func TestRules(t *testing.T) {
+	tb := newTestBot(t)
+	preferences := Preferences{UserID: "picky"}
+	preferences.SetRule(testClass, []schools.ClassStatus{schools.OPENED})
+	tb.store.SavePreferences(preferences)
+	event := Event{URI: testClass, Subscribers: []string{"picky", "anyone"}, ClassDetails: fullDetails}
+
+	tb.discord.UpdateSubscriber(Change{Event: event, Previous: openDetails})
+	if len(tb.session.messages["dm-picky"]) != 0 || len(tb.session.messages["dm-anyone"]) != 1 {
+		t.Error("change to FULL was alerted despite the rule of the class")
+	}
+	event.ClassDetails = openDetails
+	tb.discord.UpdateSubscriber(Change{Event: event, Previous: fullDetails})
+	if len(tb.session.messages["dm-picky"]) != 1 {
+		t.Error("change to OPENED was not alerted")
+	}
+
+	preferences.SetRule(testClass, nil)
+	if len(preferences.Rules) != 0 || !preferences.Wants(Change{Event: event}) {
+		t.Errorf("rules = %+v, want them cleared", preferences.Rules)
+	}
+}
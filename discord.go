@@ -1,42 +1,196 @@
 package class_notify
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/bwmarrin/discordgo"
-	"log"
+	"github.com/zMrKrabz/class-notify/schools"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Discord struct {
-	session            *discordgo.Session
-	registeredCommands []*discordgo.ApplicationCommand
-	guildID string
-	Bot     *Bot
+	// conn is the gateway connection, session what handlers talk to discord with
+	conn    *discordgo.Session
+	session Session
+	// appID is the id of the bot's user, which is also its application's id
+	appID string
+	// syncedGuilds holds the guilds whose commands match the manifest
+	syncedGuilds map[string]bool
+	commandsMu   sync.Mutex
+	guildID      string
+	Bot          *Bot
+	// AdminChannelID is the channel operational alerts are posted to
+	AdminChannelID string
+	// Operators are the ids of users allowed to use /admin
+	Operators []string
+	// RateLimit is the least time between two alerts of a class to a user, alerts
+	// coming sooner are merged and sent once it passed
+	RateLimit time.Duration
+	// StatusCooldown is the least time between two /status checks of a user, which
+	// each fetch the registrar
+	StatusCooldown time.Duration
+	// PublicURL is where users reach the http server, /classes links feeds when set
+	PublicURL string
+	// Dashboard, when set, is logged in to with links sent by /dashboard
+	Dashboard *Dashboard
+
+	alertsMu   sync.Mutex
+	lastAlerts map[userEvent]time.Time
+	lastStatus map[string]time.Time
+
+	gatewayMu      sync.Mutex
+	connected      bool
+	gatewayChanged time.Time
+}
+
+type userEvent struct {
+	user, uri string
 }
 
+// Connect opens a discord session and registers commands in every guild the bot is
+// in, or only in guildID when it is not empty
 func (d *Discord) Connect(token string, guildID string) error {
-	s, err := discordgo.New("Bot " + token)
-	if err != nil {
-		return errors.New(fmt.Sprintf("connecting to discord: %s", err))
+	if err := d.Login(token); err != nil {
+		return err
 	}
+	s := d.conn
 	d.guildID = guildID
-	d.session = s
+	d.syncedGuilds = make(map[string]bool)
 
+	s.AddHandler(func(s *discordgo.Session, _ *discordgo.Connect) {
+		d.setConnected(true)
+	})
+	s.AddHandler(func(s *discordgo.Session, _ *discordgo.Disconnect) {
+		slog.Warn("disconnected from the discord gateway")
+		d.setConnected(false)
+	})
 	s.AddHandler(func(s *discordgo.Session, ready *discordgo.Ready) {
-		log.Println("Bot is now running, press CTRL + C to close")
+		slog.Info("connected to discord", "bot", ready.User.ID, "guilds", len(ready.Guilds))
+	})
+	// guilds are created once on startup for every guild the bot is in, and again
+	// whenever it joins one
+	s.AddHandler(func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		if d.guildID != "" && g.ID != d.guildID {
+			return
+		}
+		d.syncGuild(g.ID)
+	})
+
+	s.AddHandler(func(_ *discordgo.Session, i *discordgo.InteractionCreate) {
+		d.handleInteraction(i)
 	})
 
 	if err := s.Open(); err != nil {
 		return fmt.Errorf("unable to open discord session: %s", err)
 	}
-	commands := []*discordgo.ApplicationCommand{
+	return nil
+}
+
+func (d *Discord) setConnected(connected bool) {
+	d.gatewayMu.Lock()
+	defer d.gatewayMu.Unlock()
+	d.connected = connected
+	d.gatewayChanged = time.Now()
+}
+
+// Connected reports whether the discord gateway is connected and since when it
+// is or is not, which is the zero time when it never connected
+func (d *Discord) Connected() (bool, time.Time) {
+	d.gatewayMu.Lock()
+	defer d.gatewayMu.Unlock()
+	return d.connected, d.gatewayChanged
+}
+
+type interactionHandler func(s Session, i *discordgo.InteractionCreate)
+
+// handleInteraction routes i to the handler of its command or component
+func (d *Discord) handleInteraction(i *discordgo.InteractionCreate) {
+	commandHandlers := map[string]interactionHandler{
+		"subscribe":   d.subscribe,
+		"unsubscribe": d.unsubscribe,
+		"classes":     d.classes,
+		"dashboard":   d.dashboard,
+		"config":      d.config,
+		"admin":       d.admin,
+		"status":      d.status,
+		"preferences": d.preferences,
+
+		"subscribe-channel":   d.subscribeChannel,
+		"unsubscribe-channel": d.unsubscribeChannel,
+	}
+	componentHandlers := map[string]interactionHandler{
+		subscribeButtonID: d.subscribeButton,
+	}
+
+	var h interactionHandler
+	var name string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name = i.ApplicationCommandData().Name
+		h = commandHandlers[name]
+	case discordgo.InteractionMessageComponent:
+		h = componentHandlers[i.MessageComponentData().CustomID]
+	}
+	if h == nil {
+		return
+	}
+	// components are told apart from the commands they may share a name with
+	label := name
+	if i.Type == discordgo.InteractionMessageComponent {
+		label = "component:" + i.MessageComponentData().CustomID
+	}
+	d.Bot.Metrics.interaction(label)
+	interactionLogger(i).Debug("handling interaction", "name", label, "guild", i.GuildID)
+	// admins must always be able to reach /config and /admin, even from a disallowed channel
+	if name != "config" && name != "admin" && !d.guildSettings(i).ChannelAllowed(i.ChannelID) {
+		reply(d.session, i, "commands cannot be used in this channel", true)
+		return
+	}
+	h(d.session, i)
+}
+
+func (d *Discord) commands() []*discordgo.ApplicationCommand {
+	schoolChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, id := range d.Bot.Schools.IDs() {
+		schoolChoices = append(schoolChoices, &discordgo.ApplicationCommandOptionChoice{Name: id, Value: id})
+	}
+	channelOption := func(description string) []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{{
+			Name:         "channel",
+			Description:  description,
+			Type:         discordgo.ApplicationCommandOptionChannel,
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+			Required:     true,
+		}}
+	}
+
+	urlOption := func(description string) []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{{
+			Name:        "url",
+			Description: description,
+			Type:        discordgo.ApplicationCommandOptionString,
+			Required:    true,
+		}}
+	}
+
+	statusChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, status := range []schools.ClassStatus{schools.OPENED, schools.WAITLISTED, schools.FULL} {
+		statusChoices = append(statusChoices, &discordgo.ApplicationCommandOptionChoice{Name: string(status), Value: string(status)})
+	}
+
+	return []*discordgo.ApplicationCommand{
 		{
 			Name:        "subscribe",
 			Description: "Adds you to the alert list of a class",
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Name:        "url",
-					Description: "url of class to add you to",
+					Description: "url of class to add you to, or <school>:<course> such as GEORGIA_TECH:202208/80123",
 					Type:        discordgo.ApplicationCommandOptionString,
 					Required:    true,
 				},
@@ -48,7 +202,7 @@ func (d *Discord) Connect(token string, guildID string) error {
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Name:        "url",
-					Description: "url of class to remove you from",
+					Description: "url of class to remove you from, or <school>:<course>",
 					Type:        discordgo.ApplicationCommandOptionString,
 					Required:    true,
 				},
@@ -58,145 +212,1214 @@ func (d *Discord) Connect(token string, guildID string) error {
 			Name:        "classes",
 			Description: "Lists all classes you are subscribed to",
 		},
+		{
+			Name:        "dashboard",
+			Description: "Sends you a link to manage your classes and preferences on the web",
+		},
+		{
+			Name:        "status",
+			Description: "Checks the seats of a class right away, without subscribing",
+			Options:     urlOption("url of class to check, or <school>:<course>"),
+		},
+		{
+			Name:        "subscribe-channel",
+			Description: "Posts the alerts of a class to a channel of this server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "url",
+					Description: "url of class to post alerts of, or <school>:<course>",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:         "channel",
+					Description:  "channel to post to, the server's notification channel or this one by default",
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+				},
+				{
+					Name:        "role",
+					Description: "role to ping with every alert",
+					Type:        discordgo.ApplicationCommandOptionRole,
+				},
+			},
+		},
+		{
+			Name:        "unsubscribe-channel",
+			Description: "Stops posting the alerts of a class to a channel of this server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "url",
+					Description: "url of class to stop posting alerts of, or <school>:<course>",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:         "channel",
+					Description:  "channel to stop posting to, the server's notification channel or this one by default",
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+				},
+			},
+		},
+		{
+			Name:        "config",
+			Description: "Configures the bot for this server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "show",
+					Description: "Shows the settings of this server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "default-school",
+					Description: "Sets the school of course identifiers given without one",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{{
+						Name:        "school",
+						Description: "school to default to",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Choices:     schoolChoices,
+						Required:    true,
+					}},
+				},
+				{
+					Name:        "allow-channel",
+					Description: "Allows commands in a channel, commands are allowed everywhere until one is added",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options:     channelOption("channel to allow commands in"),
+				},
+				{
+					Name:        "disallow-channel",
+					Description: "Removes a channel from the channels commands are allowed in",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options:     channelOption("channel to disallow commands in"),
+				},
+				{
+					Name:        "notification-channel",
+					Description: "Sets the channel class alerts for this server are posted to",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options:     channelOption("channel to post alerts to"),
+				},
+				{
+					Name:        "admin-role",
+					Description: "Sets a role allowed to configure the bot besides members with Manage Server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{{
+						Name:        "role",
+						Description: "role of bot admins",
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Required:    true,
+					}},
+				},
+				{
+					Name:        "locale",
+					Description: "Sets the language of channel alerts and /status in this server, such as fr",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{{
+						Name:        "locale",
+						Description: "discord locale code",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					}},
+				},
+			},
+		},
+		{
+			Name:        "preferences",
+			Description: "Sets how you are alerted",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "show",
+					Description: "Shows your preferences",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "timezone",
+					Description: "Sets the timezone of your quiet hours",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{{
+						Name:        "zone",
+						Description: "timezone name such as America/New_York",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					}},
+				},
+				{
+					Name:        "quiet-hours",
+					Description: "Holds alerts back between two times of day, until the quiet hours end",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "start",
+							Description: "start of quiet hours such as 22:00",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "end",
+							Description: "end of quiet hours such as 07:00",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "quiet-hours-off",
+					Description: "Alerts you at any time",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "urgent",
+					Description: "Alerts a change of status right away, even during quiet hours",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "to",
+							Description: "new status",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     statusChoices,
+							Required:    true,
+						},
+						{
+							Name:        "from",
+							Description: "previous status, any status when left out",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     statusChoices,
+						},
+					},
+				},
+				{
+					Name:        "urgent-clear",
+					Description: "Holds back every alert during quiet hours",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "digest",
+					Description: "Gathers your alerts into one summary sent hourly or daily",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "frequency",
+							Description: "how often the digest is sent",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "off, send every alert right away", Value: "off"},
+								{Name: "hourly", Value: DigestHourly},
+								{Name: "daily", Value: DigestDaily},
+							},
+							Required: true,
+						},
+						{
+							Name:        "at",
+							Description: "time of day daily digests are sent, such as 09:00",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+					},
+				},
+				{
+					Name:        "immediate",
+					Description: "Alerts a class right away instead of in your digest",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "url",
+							Description: "url of the class, or <school>:<course>",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+						},
+						{
+							Name:        "enabled",
+							Description: "false puts the class back in your digest",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "admin",
+			Description: "Operates the bot, only usable by its operators",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "stats",
+					Description: "Shows events, subscribers, check durations and error rates of every school",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "pause",
+					Description: "Stops checking classes",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "resume",
+					Description: "Resumes checking classes",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "forcecheck",
+					Description: "Checks a class right away",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options:     urlOption("url of class to check, or <school>:<course>"),
+				},
+				{
+					Name:        "remove-event",
+					Description: "Stops monitoring a class for every subscriber",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options:     urlOption("url of class to remove, or <school>:<course>"),
+				},
+				{
+					Name:        "broadcast",
+					Description: "Sends a notice to every subscriber and channel, such as upcoming maintenance",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{{
+						Name:        "message",
+						Description: "notice to send",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					}},
+				},
+			},
+		},
 	}
-	commandHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"subscribe":   d.subscribe,
-		"unsubscribe": d.unsubscribe,
-		"classes":     d.classes,
+}
+
+// Close disconnects from discord. Commands stay registered so that they keep
+// working across restarts, use the commands subcommand of the cli to remove them.
+func (d *Discord) Close() {
+	d.conn.Close()
+}
+
+// guildSettings returns the settings of the guild i was created in, which are
+// empty for DMs
+func (d *Discord) guildSettings(i *discordgo.InteractionCreate) GuildSettings {
+	if i.GuildID == "" {
+		return GuildSettings{}
 	}
-	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-			h(s, i)
-		}
-	})
+	settings, err := d.Bot.DB.GetGuildSettings(i.GuildID)
+	if err != nil {
+		interactionLogger(i).Warn("getting guild settings failed", "guild", i.GuildID, "err", err)
+		return GuildSettings{GuildID: i.GuildID}
+	}
+	return settings
+}
 
-	registeredCommands := make([]*discordgo.ApplicationCommand, len(commands))
-	for i, v := range commands {
-		cmd, err := s.ApplicationCommandCreate(s.State.User.ID, guildID, v)
+// reply responds to i with content, only visible to its user when ephemeral
+func reply(s Session, i *discordgo.InteractionCreate, content string, ephemeral bool) {
+	data := &discordgo.InteractionResponseData{Content: content}
+	if ephemeral {
+		data.Flags = uint64(discordgo.MessageFlagsEphemeral)
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	}); err != nil {
+		interactionLogger(i).Warn("responding to interaction failed", "err", err)
+	}
+}
+
+// deferReply acknowledges i right away so that it can be answered later with
+// editReply, it reports whether acknowledging worked
+func deferReply(s Session, i *discordgo.InteractionCreate, ephemeral bool) bool {
+	data := &discordgo.InteractionResponseData{}
+	if ephemeral {
+		data.Flags = uint64(discordgo.MessageFlagsEphemeral)
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: data,
+	}); err != nil {
+		interactionLogger(i).Warn("deferring interaction failed", "err", err)
+		return false
+	}
+	return true
+}
+
+// maxMessageLength is the most characters discord accepts in a message
+const maxMessageLength = 2000
+
+// editReply answers a deferred interaction with content
+func editReply(s Session, i *discordgo.InteractionCreate, content string) {
+	if len(content) > maxMessageLength {
+		content = content[:maxMessageLength-3] + "..."
+	}
+	editResponse(s, i, &discordgo.WebhookEdit{Content: content})
+}
+
+func editResponse(s Session, i *discordgo.InteractionCreate, edit *discordgo.WebhookEdit) {
+	if edit.Components == nil {
+		edit.Components = []discordgo.MessageComponent{}
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, edit); err != nil {
+		interactionLogger(i).Warn("editing interaction response failed", "err", err)
+	}
+}
+
+// explain describes err to the user whose command failed because of it
+func explain(err error) string {
+	var parseErr *schools.ParseError
+	var statusErr *schools.StatusError
+	switch {
+	case errors.Is(err, ErrAlreadySubscribed):
+		return "you are already subscribed to this class"
+	case errors.Is(err, ErrNotSubscribed):
+		return "you are not subscribed to this class"
+	case errors.Is(err, ErrNoSuchChannel):
+		return "this channel is not subscribed to this class"
+	case errors.Is(err, ErrNoSuchEvent):
+		return "this class is not being tracked"
+	case errors.Is(err, schools.ErrUnknownSchool):
+		return "this is not the url of a class of a school served by this bot, " +
+			"use the url of the class page or <school>:<course> such as GEORGIA_TECH:202208/80123"
+	case errors.Is(err, schools.ErrInvalidClass):
+		return "this does not look like a class url or course identifier of its school, check it for typos"
+	case schools.IsUnavailable(err):
+		return "the school's site is not responding right now, try again in a few minutes"
+	case errors.As(err, &parseErr):
+		return "the class page could not be read, the school may have changed its site"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("the school could not find this class (%s), check the url", statusErr.Status)
+	}
+	return "something went wrong on our side, try again later"
+}
+
+// interactionUserID returns the id of the user who created i, in a guild or in DMs
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.User == nil {
+		return i.Member.User.ID
+	}
+	return i.User.ID
+}
+
+// interactionLogger returns the logger of the handling of i, whose logs are
+// correlated by the id of the interaction
+func interactionLogger(i *discordgo.InteractionCreate) *slog.Logger {
+	return slog.With("interaction", i.ID, userKey, interactionUserID(i))
+}
+
+var ErrUserUnavailable = errors.New("class_notify: unable to create DM with user")
+
+// UpdateSubscriber sends the new status of a class to the subscribers of its event
+// whose rules let it through by DM, and to its channels. Alerts of classes in a user's digest are queued until
+// the digest is sent, alerts to users in their quiet hours until the quiet hours
+// end and alerts over the rate limit until it passed. Every target is tried, the
+// first error is returned.
+func (d *Discord) UpdateSubscriber(change Change) error {
+	var firstErr error
+	now := time.Now()
+	for _, s := range change.Event.Subscribers {
+		preferences, err := d.Bot.DB.GetPreferences(s)
 		if err != nil {
-			fmt.Printf("Could not add command %s because of err: %s", v.Name, err)
+			slog.Warn("getting preferences failed", "check", change.CheckID, userKey, s, "err", err)
+		}
+		if !preferences.Wants(change) {
+			slog.Debug("alert left out by the rules of the user", "check", change.CheckID, "event", change.Event.URI, userKey, s)
+			continue
+		}
+		if alert, held := d.hold(s, preferences, change, now); held {
+			if err := d.Bot.DB.QueueAlert(alert); err != nil {
+				slog.Warn("queueing alert failed, sending it now", "check", change.CheckID, userKey, s, "err", err)
+			} else {
+				d.Bot.Metrics.queued()
+				slog.Info("alert queued", "check", change.CheckID, "event", change.Event.URI, userKey, s,
+					"until", alert.DeliverAt, "digest", alert.Digest, "reason", alert.Reason)
+				continue
+			}
+		}
+		if err := d.sendDM(s, statusEmbed(change, english)); err != nil {
+			slog.Warn("sending alert failed", "check", change.CheckID, "event", change.Event.URI, userKey, s, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			slog.Info("alert sent", "check", change.CheckID, "event", change.Event.URI, userKey, s)
+			d.alerted(s, change.Event.URI, now)
+		}
+	}
+	for _, target := range change.Event.Channels {
+		message := &discordgo.MessageSend{Embed: statusEmbed(change, d.guildMessages(target.GuildID))}
+		if target.RoleID != "" {
+			message.Content = fmt.Sprintf("<@&%s>", target.RoleID)
+			message.AllowedMentions = &discordgo.MessageAllowedMentions{Roles: []string{target.RoleID}}
+		}
+		_, err := d.session.ChannelMessageSendComplex(target.ChannelID, message)
+		d.Bot.Metrics.notification(backendChannel, err)
+		if err != nil {
+			slog.Warn("sending alert failed", "check", change.CheckID, "event", change.Event.URI, "channel", target.ChannelID, "err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unable to send message to channel %s: %s", target.ChannelID, err)
+			}
+		} else {
+			slog.Info("alert sent", "check", change.CheckID, "event", change.Event.URI, "channel", target.ChannelID)
+		}
+	}
+	return firstErr
+}
+
+// hold returns the alert to queue when change should not be sent to userID at now
+func (d *Discord) hold(userID string, preferences Preferences, change Change, now time.Time) (QueuedAlert, bool) {
+	alert := QueuedAlert{UserID: userID, Change: change}
+	if preferences.InDigest(change.Event.URI) {
+		alert.Digest = true
+		alert.DeliverAt = preferences.NextDigest(now)
+		return alert, true
+	}
+	if hold, until := preferences.Hold(change, now); hold {
+		alert.Reason = HeldForQuietHours
+		alert.DeliverAt = until
+		return alert, true
+	}
+	if d.RateLimit > 0 {
+		d.alertsMu.Lock()
+		last, ok := d.lastAlerts[userEvent{userID, change.Event.URI}]
+		d.alertsMu.Unlock()
+		if ok && now.Sub(last) < d.RateLimit {
+			alert.Reason = HeldForRateLimit
+			alert.DeliverAt = last.Add(d.RateLimit)
+			return alert, true
 		}
-		fmt.Printf("registered command %s\n", v.Name)
-		registeredCommands[i] = cmd
 	}
-	d.registeredCommands = registeredCommands
+	return QueuedAlert{}, false
+}
 
+// alerted records that userID was alerted of the class of uri at, and forgets
+// the alerts the rate limit no longer holds back
+func (d *Discord) alerted(userID string, uri string, at time.Time) {
+	if d.RateLimit <= 0 {
+		return
+	}
+	d.alertsMu.Lock()
+	defer d.alertsMu.Unlock()
+	if d.lastAlerts == nil {
+		d.lastAlerts = make(map[userEvent]time.Time)
+	}
+	for alert, last := range d.lastAlerts {
+		if at.Sub(last) >= d.RateLimit {
+			delete(d.lastAlerts, alert)
+		}
+	}
+	d.lastAlerts[userEvent{userID, uri}] = at
+}
+
+func (d *Discord) sendDM(userID string, embed *discordgo.MessageEmbed) error {
+	err := d.dm(userID, embed)
+	d.Bot.Metrics.notification(backendDM, err)
+	return err
+}
+
+func (d *Discord) dm(userID string, embed *discordgo.MessageEmbed) error {
+	channel, err := d.session.UserChannelCreate(userID)
+	if err != nil {
+		// TODO: on this error, delete user from list of subscribers
+		return ErrUserUnavailable
+	}
+	if _, err := d.session.ChannelMessageSendEmbed(channel.ID, embed); err != nil {
+		return fmt.Errorf("unable to send message to user: %s", err)
+	}
 	return nil
 }
 
-func (d *Discord) Close() {
-	for _, v := range d.registeredCommands {
-		err := d.session.ApplicationCommandDelete(d.session.State.User.ID, d.guildID, v.ID)
-		if err != nil {
-			log.Println(fmt.Sprintf("unable to delete command %s, error: %s", v.Name, err))
+// guildMessages returns the messages in the locale of guildID
+func (d *Discord) guildMessages(guildID string) messages {
+	settings, err := d.Bot.DB.GetGuildSettings(guildID)
+	if err != nil {
+		slog.Warn("getting guild settings failed", "guild", guildID, "err", err)
+	}
+	return messagesFor(settings.Locale)
+}
+
+// statusEmbed describes change with the messages of m
+func statusEmbed(change Change, m messages) *discordgo.MessageEmbed {
+	event := change.Event
+	embed := &discordgo.MessageEmbed{
+		URL:         event.URI,
+		Title:       m.changedTo(event.ClassDetails.Status),
+		Description: event.ClassDetails.Name,
+	}
+	if change.Previous.Status != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: m.wasStatus(change.Previous.Status)}
+	}
+	if change.Brief != nil {
+		embed.Title = fmt.Sprintf(m.briefly, m.statusName(change.Brief.Status))
+		embed.Description = m.brief(event.ClassDetails.Name, *change.Brief, event.ClassDetails.Status)
+		embed.Footer = nil
+	}
+	if !change.At.IsZero() {
+		embed.Timestamp = change.At.Format(time.RFC3339)
+	}
+	return embed
+}
+
+// queueLease is how long alerts claimed for delivery are left alone, after which
+// alerts of a delivery that never finished are claimed again
+const queueLease = 10 * time.Minute
+
+// StartQueue delivers alerts held back by quiet hours once they are due, checking
+// every interval
+func (d *Discord) StartQueue(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := d.DeliverQueued(time.Now()); err != nil {
+			slog.Error("delivering queued alerts failed", "err", err)
+		}
+	}
+}
+
+// DeliverQueued sends the queued alerts due at now. Alerts of the same class for a
+// user are merged into one, going from the status before the first to the status
+// after the last. Digest alerts of a user are sent as one summary, other alerts
+// are dropped when the class is back to the status it had before the quiet hours.
+// Alerts stay queued until they were sent, those that could not be are delivered
+// again next time.
+func (d *Discord) DeliverQueued(now time.Time) error {
+	alerts, err := d.Bot.DB.ClaimDueAlerts(now, queueLease)
+	if err != nil {
+		return err
+	}
+	type key struct {
+		user, uri string
+		digest    bool
+	}
+	merged := make(map[key]mergedChange)
+	order := make([]key, 0, len(alerts))
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Change.At.Before(alerts[j].Change.At) })
+	for _, alert := range alerts {
+		k := key{alert.UserID, alert.Change.Event.URI, alert.Digest}
+		m, ok := merged[k]
+		if !ok {
+			order = append(order, k)
+			merged[k] = mergedChange{Change: alert.Change, Count: 1, Reason: alert.Reason, ids: []string{alert.ID}}
+			continue
+		}
+		alert.Change.Previous = m.Previous
+		merged[k] = mergedChange{Change: alert.Change, Count: m.Count + 1, Reason: alert.Reason, ids: append(m.ids, alert.ID)}
+	}
+
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	digests := make(map[string][]mergedChange)
+	var digestUsers []string
+	for _, k := range order {
+		m := merged[k]
+		if k.digest {
+			if _, ok := digests[k.user]; !ok {
+				digestUsers = append(digestUsers, k.user)
+			}
+			digests[k.user] = append(digests[k.user], m)
+			continue
+		}
+		// a single brief change says what happened, merged ones went nowhere
+		if m.Previous.Status == m.Event.ClassDetails.Status && (m.Brief == nil || m.Count > 1) {
+			if err := d.Bot.DB.AckAlerts(m.ids); err != nil {
+				fail(err)
+			}
+			continue
+		}
+		embed := statusEmbed(m.Change, english)
+		if m.Count > 1 || m.Brief == nil {
+			embed.Title = english.changedTo(m.Event.ClassDetails.Status)
+			reason := "held during your quiet hours"
+			if m.Reason == HeldForRateLimit {
+				reason = fmt.Sprintf("changed %d times since your last alert", m.Count)
+			}
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("was %s, %s", m.Previous.Status, reason)}
+		}
+		err := d.sendDM(k.user, embed)
+		if err == nil {
+			d.alerted(k.user, k.uri, now)
+			slog.Info("queued alert delivered", "check", m.CheckID, "event", k.uri, userKey, k.user, "merged", m.Count)
+		} else {
+			slog.Warn("delivering queued alert failed", "check", m.CheckID, "event", k.uri, userKey, k.user, "err", err)
+			fail(err)
+		}
+		if err := d.settle(m.ids, err); err != nil {
+			fail(err)
 		}
 	}
+	for _, user := range digestUsers {
+		var ids []string
+		for _, m := range digests[user] {
+			ids = append(ids, m.ids...)
+		}
+		err := d.sendDM(user, digestEmbed(digests[user]))
+		if err == nil {
+			slog.Info("digest delivered", userKey, user, "classes", len(digests[user]))
+		} else {
+			slog.Warn("delivering digest failed", userKey, user, "classes", len(digests[user]), "err", err)
+			fail(err)
+		}
+		if err := d.settle(ids, err); err != nil {
+			fail(err)
+		}
+	}
+	return firstErr
+}
 
-	d.session.Close()
+// settle removes the queued alerts of ids once sent, or when their user cannot be
+// sent DMs at all, and puts them back in the queue when sending them failed
+func (d *Discord) settle(ids []string, sendErr error) error {
+	if sendErr != nil && !errors.Is(sendErr, ErrUserUnavailable) {
+		return d.Bot.DB.ReleaseAlerts(ids)
+	}
+	return d.Bot.DB.AckAlerts(ids)
 }
 
-var ErrUserUnavailable = errors.New("class_notify: unable to create DM with user")
+// mergedChange is the net change of a class over Count alerts
+type mergedChange struct {
+	Change
+	Count  int
+	Reason string
+	// ids are those of the queued alerts merged
+	ids []string
+}
 
-func (d *Discord) UpdateSubscriber(event Event) error {
-	for _, s := range event.Subscribers {
-		channel, err := d.session.UserChannelCreate(s)
-		if err != nil {
-			// TODO: on this error, delete user from list of subscribers
-			return ErrUserUnavailable
+// maxEmbedFields is the most fields discord accepts in an embed
+const maxEmbedFields = 25
+
+// digestEmbed summarises the current status and net seat change of every class
+// of a digest
+func digestEmbed(changes []mergedChange) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     fmt.Sprintf("Digest of %d classes", len(changes)),
+		Color:     0x3498db,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for i, m := range changes {
+		if i == maxEmbedFields {
+			embed.Description = fmt.Sprintf("and %d more classes, use /classes to see them all", len(changes)-maxEmbedFields)
+			break
+		}
+		current := m.Event.ClassDetails
+		value := fmt.Sprintf("[%s](%s), was %s", current.Status, m.Event.URI, m.Previous.Status)
+		if m.Count > 1 {
+			value += fmt.Sprintf(", changed %d times", m.Count)
+		}
+		if m.Brief != nil && m.Count == 1 {
+			value += fmt.Sprintf(", briefly %s for %s", m.Brief.Status, m.Brief.Duration.Round(time.Second))
 		}
-		if _, err := d.session.ChannelMessageSendEmbed(channel.ID, &discordgo.MessageEmbed{
-			URL:         event.URI,
-			Title:       fmt.Sprintf("CLASS STATUS HAS CHANGED TO %s", event.ClassDetails.Status),
-			Description: event.ClassDetails.Name,
-		}); err != nil {
-			return fmt.Errorf("unable to send message to user: %s", err)
+		value += fmt.Sprintf("\nseats %+d, %d of %d remaining",
+			current.SeatsRemaining-m.Previous.SeatsRemaining, current.SeatsRemaining, current.SeatsTotal)
+		name := current.Name
+		if name == "" {
+			name = m.Event.URI
 		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: name, Value: value})
+	}
+	return embed
+}
+
+// AlertAdmin posts a scraper health alert to the admin channel, attaching the page
+// that failed to parse
+func (d *Discord) AlertAdmin(alert schools.HealthAlert) error {
+	if d.AdminChannelID == "" {
+		return errors.New("no admin channel configured")
+	}
+	if alert.Recovered {
+		_, err := d.session.ChannelMessageSendEmbed(d.AdminChannelID, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("Scraper for %s recovered", alert.School),
+			Description: fmt.Sprintf("pages are being parsed again after %d parse failures", alert.Failures),
+			Color:       0x2ecc71,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to send recovery alert: %s", err)
+		}
+		return nil
+	}
+
+	fingerprints := make([]string, 0, len(alert.Fingerprints))
+	for fingerprint, count := range alert.Fingerprints {
+		fingerprints = append(fingerprints, fmt.Sprintf("`%s` x%d", fingerprint, count))
+	}
+	if len(fingerprints) == 0 {
+		fingerprints = append(fingerprints, "none")
+	}
+	message := &discordgo.MessageSend{
+		Embed: &discordgo.MessageEmbed{
+			URL:         alert.URI,
+			Title:       fmt.Sprintf("Scraper for %s is failing", alert.School),
+			Description: alert.LastError,
+			Color:       0xe74c3c,
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "Consecutive failures", Value: fmt.Sprint(alert.ConsecutiveFailures), Inline: true},
+				{Name: "Failing events", Value: fmt.Sprint(alert.FailingEvents), Inline: true},
+				{Name: "Failure rate", Value: fmt.Sprintf("%.0f%%", alert.FailureRate*100), Inline: true},
+				{Name: "Page fingerprints", Value: strings.Join(fingerprints, "\n")},
+			},
+		},
+	}
+	if alert.Body != nil {
+		message.Files = []*discordgo.File{{
+			Name:        fmt.Sprintf("%s-%s.html", alert.School, alert.Fingerprint),
+			ContentType: "text/html",
+			Reader:      bytes.NewReader(alert.Body),
+		}}
+	}
+	if _, err := d.session.ChannelMessageSendComplex(d.AdminChannelID, message); err != nil {
+		return fmt.Errorf("unable to send scraper alert: %s", err)
 	}
 	return nil
 }
 
-func (d *Discord) subscribe(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	options := i.ApplicationCommandData().Options
-	uri := options[0].StringValue()
-	var userID string
-	// checks if the interaction was created in a guild or in DMs
-	if i.User == nil {
-		userID = i.Member.User.ID
-	} else {
-		userID = i.User.ID
+func (d *Discord) subscribe(s Session, i *discordgo.InteractionCreate) {
+	// subscribing to a new class checks it first, which can outlast the time
+	// discord waits for a response
+	if !deferReply(s, i, true) {
+		return
 	}
-	event, err := d.Bot.Subscribe(uri, userID)
+	uri := optionMap(i.ApplicationCommandData().Options)["url"].StringValue()
+	userID := interactionUserID(i)
+	event, err := d.Bot.Subscribe(uri, d.guildSettings(i).DefaultSchool, userID)
 	if err != nil {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "unable to add you to event",
-			},
-		})
-		log.Printf("unable to add user %s to event %s: %s\n", userID, uri, err)
+		editReply(s, i, "Unable to subscribe you: "+explain(err))
+		interactionLogger(i).Warn("subscribing failed", "event", uri, "err", err)
 		return
 	}
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			// TODO pretty this up
-			Content: fmt.Sprintf("Added you to class %s", event.ClassDetails.Name),
-		},
-	})
+	editReply(s, i, fmt.Sprintf("Added you to class %s", event.ClassDetails.Name))
 }
 
-func (d *Discord) unsubscribe(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	options := i.ApplicationCommandData().Options
-	uri := options[0].StringValue()
-	var userID string
-	// checks if the interaction was created in a guild or in DMs
-	if i.User == nil {
-		userID = i.Member.User.ID
-	} else {
-		userID = i.User.ID
+func (d *Discord) unsubscribe(s Session, i *discordgo.InteractionCreate) {
+	if !deferReply(s, i, true) {
+		return
 	}
-	event, err := d.Bot.Unsubscribe(uri, userID)
+	uri := optionMap(i.ApplicationCommandData().Options)["url"].StringValue()
+	userID := interactionUserID(i)
+	event, err := d.Bot.Unsubscribe(uri, d.guildSettings(i).DefaultSchool, userID)
 	if err != nil {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "unable to subscribe from class",
-			},
-		})
-		log.Printf("unable to unsubsribe user %s from event %s because: %s", userID, uri, err)
+		if errors.Is(err, ErrNoSuchEvent) {
+			err = ErrNotSubscribed
+		}
+		editReply(s, i, "Unable to unsubscribe you: "+explain(err))
+		interactionLogger(i).Warn("unsubscribing failed", "event", uri, "err", err)
 		return
 	}
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("Unsubscribed from class with name %s", event.ClassDetails.Name),
-		},
-	})
+	editReply(s, i, fmt.Sprintf("Unsubscribed from class with name %s", event.ClassDetails.Name))
 }
 
-func (d *Discord) classes(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	var userID string
-	// checks if the interaction was created in a guild or in DMs
-	if i.User == nil {
-		userID = i.Member.User.ID
-	} else {
-		userID = i.User.ID
+func (d *Discord) classes(s Session, i *discordgo.InteractionCreate) {
+	if !deferReply(s, i, true) {
+		return
 	}
+	userID := interactionUserID(i)
 	events, err := d.Bot.GetUserEvents(userID)
 	if err != nil {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: "unable to list your classes",
-			},
-		})
-		log.Printf("unable to list classes of %s: %s", userID, err)
+		editReply(s, i, "Unable to list your classes: "+explain(err))
+		interactionLogger(i).Warn("listing classes failed", "err", err)
 		return
 	}
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("subscribed to %v classes, waiting on embed implementation", len(events)),
+	if len(events) == 0 {
+		editReply(s, i, "You are not subscribed to any class, use /subscribe to add one")
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are subscribed to %d classes:\n", len(events))
+	base := strings.TrimSuffix(d.PublicURL, "/")
+	for _, event := range events {
+		fmt.Fprintf(&b, "- [%s](<%s>): %s", event.ClassDetails.Name, event.URI, event.ClassDetails.Status)
+		if base != "" {
+			fmt.Fprintf(&b, " ([feed](<%s>))", classFeedURL(base, event.URI))
+		}
+		b.WriteString("\n")
+	}
+	if base != "" {
+		if token, err := d.Bot.FeedToken(userID); err != nil {
+			interactionLogger(i).Warn("getting feed token failed", "err", err)
+		} else {
+			fmt.Fprintf(&b, "Follow all your classes in a feed reader with <%s>, keep it to yourself\n", userFeedURL(base, token))
+		}
+	}
+	editReply(s, i, b.String())
+}
+
+func (d *Discord) dashboard(s Session, i *discordgo.InteractionCreate) {
+	if d.Dashboard == nil {
+		reply(s, i, "The web dashboard is not enabled on this bot", true)
+		return
+	}
+	if !deferReply(s, i, true) {
+		return
+	}
+	userID := interactionUserID(i)
+	link, err := d.Dashboard.LoginURL(userID, time.Now())
+	if err != nil {
+		editReply(s, i, "Unable to create a login link: "+explain(err))
+		interactionLogger(i).Warn("creating login link failed", "err", err)
+		return
+	}
+	embed := &discordgo.MessageEmbed{
+		Title: "Log in to your dashboard",
+		URL:   link,
+		Description: fmt.Sprintf("This link logs you in once within %s, do not share it.",
+			d.Dashboard.LoginTTL.Round(time.Minute)),
+	}
+	if err := d.sendDM(userID, embed); err != nil {
+		editReply(s, i, "Unable to send you a login link, check that you accept DMs from server members")
+		interactionLogger(i).Warn("sending login link failed", "err", err)
+		return
+	}
+	interactionLogger(i).Info("login link sent")
+	editReply(s, i, "I sent you a login link by DM")
+}
+
+// isGuildAdmin reports whether member may configure the bot in its guild
+func isGuildAdmin(member *discordgo.Member, settings GuildSettings) bool {
+	if member.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 {
+		return true
+	}
+	return settings.AdminRole != "" && contains(member.Roles, settings.AdminRole)
+}
+
+func (d *Discord) config(s Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil {
+		reply(s, i, "/config can only be used in a server", true)
+		return
+	}
+	settings, err := d.Bot.DB.GetGuildSettings(i.GuildID)
+	if err != nil {
+		reply(s, i, "unable to get the settings of this server", true)
+		interactionLogger(i).Warn("getting guild settings failed", "guild", i.GuildID, "err", err)
+		return
+	}
+	if !isGuildAdmin(i.Member, settings) {
+		reply(s, i, "you need the Manage Server permission or the bot admin role to configure the bot", true)
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	switch subcommand.Name {
+	case "show":
+		reply(s, i, settings.String(), true)
+		return
+	case "default-school":
+		school := subcommand.Options[0].StringValue()
+		if _, ok := d.Bot.Schools.Get(school); !ok {
+			reply(s, i, fmt.Sprintf("%s is not a school served by this bot", school), true)
+			return
+		}
+		settings.DefaultSchool = school
+	case "allow-channel":
+		channelID := subcommand.Options[0].ChannelValue(nil).ID
+		if !contains(settings.AllowedChannels, channelID) {
+			settings.AllowedChannels = append(settings.AllowedChannels, channelID)
+		}
+	case "disallow-channel":
+		channelID := subcommand.Options[0].ChannelValue(nil).ID
+		allowed := make([]string, 0, len(settings.AllowedChannels))
+		for _, c := range settings.AllowedChannels {
+			if c != channelID {
+				allowed = append(allowed, c)
+			}
+		}
+		settings.AllowedChannels = allowed
+	case "notification-channel":
+		settings.NotificationChannel = subcommand.Options[0].ChannelValue(nil).ID
+	case "admin-role":
+		settings.AdminRole = subcommand.Options[0].RoleValue(nil, "").ID
+	case "locale":
+		locale := subcommand.Options[0].StringValue()
+		if _, ok := discordgo.Locales[discordgo.Locale(locale)]; !ok {
+			reply(s, i, fmt.Sprintf("%s is not a discord locale, such as en-US or fr", locale), true)
+			return
+		}
+		settings.Locale = locale
+	default:
+		reply(s, i, "unknown setting", true)
+		return
+	}
+
+	if err := d.Bot.DB.SaveGuildSettings(settings); err != nil {
+		reply(s, i, "unable to save the settings of this server", true)
+		interactionLogger(i).Warn("saving guild settings failed", "guild", i.GuildID, "err", err)
+		return
+	}
+	reply(s, i, "Updated the settings of this server\n"+settings.String(), true)
+}
+
+// optionMap indexes the options of a command or subcommand by name
+func optionMap(options []*discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	m := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, o := range options {
+		m[o.Name] = o
+	}
+	return m
+}
+
+// targetChannel returns the channel a channel subscription command is about,
+// after checking that its user manages that channel and that the bot can post there
+func (d *Discord) targetChannel(s Session, i *discordgo.InteractionCreate, settings GuildSettings) (string, error) {
+	channelID := settings.NotificationChannel
+	if o, ok := optionMap(i.ApplicationCommandData().Options)["channel"]; ok {
+		channelID = o.ChannelValue(nil).ID
+	}
+	if channelID == "" {
+		channelID = i.ChannelID
+	}
+
+	permissions, err := s.UserChannelPermissions(i.Member.User.ID, channelID)
+	if err != nil {
+		return "", fmt.Errorf("unable to check your permissions in <#%s>", channelID)
+	}
+	if permissions&discordgo.PermissionManageChannels == 0 {
+		return "", fmt.Errorf("you need the Manage Channels permission in <#%s>", channelID)
+	}
+	botPermissions, err := s.UserChannelPermissions(d.appID, channelID)
+	if err != nil {
+		return "", fmt.Errorf("unable to check my permissions in <#%s>", channelID)
+	}
+	needed := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks)
+	if botPermissions&needed != needed {
+		return "", fmt.Errorf("I need to be able to view, send messages and embed links in <#%s>", channelID)
+	}
+	return channelID, nil
+}
+
+func (d *Discord) subscribeChannel(s Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil {
+		reply(s, i, "channel subscriptions can only be created in a server", true)
+		return
+	}
+	if !deferReply(s, i, true) {
+		return
+	}
+	settings := d.guildSettings(i)
+	channelID, err := d.targetChannel(s, i, settings)
+	if err != nil {
+		editReply(s, i, err.Error())
+		return
+	}
+	options := optionMap(i.ApplicationCommandData().Options)
+	uri := options["url"].StringValue()
+	target := ChannelTarget{GuildID: i.GuildID, ChannelID: channelID}
+	if o, ok := options["role"]; ok {
+		target.RoleID = o.RoleValue(nil, "").ID
+	}
+
+	event, err := d.Bot.SubscribeChannel(uri, settings.DefaultSchool, target)
+	if err != nil {
+		editReply(s, i, "Unable to add the channel: "+explain(err))
+		interactionLogger(i).Warn("adding channel failed", "event", uri, "channel", channelID, "err", err)
+		return
+	}
+	editReply(s, i, fmt.Sprintf("Alerts of class %s will be posted to <#%s>", event.ClassDetails.Name, channelID))
+}
+
+func (d *Discord) unsubscribeChannel(s Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil {
+		reply(s, i, "channel subscriptions can only be removed in a server", true)
+		return
+	}
+	if !deferReply(s, i, true) {
+		return
+	}
+	settings := d.guildSettings(i)
+	channelID, err := d.targetChannel(s, i, settings)
+	if err != nil {
+		editReply(s, i, err.Error())
+		return
+	}
+	uri := optionMap(i.ApplicationCommandData().Options)["url"].StringValue()
+
+	event, err := d.Bot.UnsubscribeChannel(uri, settings.DefaultSchool, i.GuildID, channelID)
+	if err != nil {
+		if errors.Is(err, ErrNoSuchChannel) || errors.Is(err, ErrNoSuchEvent) {
+			editReply(s, i, fmt.Sprintf("<#%s> is not subscribed to this class", channelID))
+			return
+		}
+		editReply(s, i, "Unable to remove the channel: "+explain(err))
+		interactionLogger(i).Warn("removing channel failed", "event", uri, "channel", channelID, "err", err)
+		return
+	}
+	editReply(s, i, fmt.Sprintf("Stopped posting alerts of class %s to <#%s>", event.ClassDetails.Name, channelID))
+}
+
+// subscribeButtonID is the custom id of the button subscribing to the class of
+// the embed it is attached to
+const subscribeButtonID = "subscribe"
+
+// detailsEmbed describes every detail of the class of event with the messages of m
+func detailsEmbed(event Event, m messages) *discordgo.MessageEmbed {
+	details := event.ClassDetails
+	color := 0xe74c3c
+	switch details.Status {
+	case schools.OPENED:
+		color = 0x2ecc71
+	case schools.WAITLISTED:
+		color = 0xf1c40f
+	}
+	return &discordgo.MessageEmbed{
+		URL:         event.URI,
+		Title:       details.Name,
+		Description: details.Description,
+		Color:       color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: m.status, Value: m.statusName(details.Status), Inline: true},
+			{Name: m.seats, Value: fmt.Sprintf(m.remaining, details.SeatsRemaining, details.SeatsTotal), Inline: true},
+			{Name: m.waitlist, Value: fmt.Sprintf(m.remaining, details.WaitlistRemaining, details.WaitlistTotal), Inline: true},
+			{Name: m.school, Value: event.School, Inline: true},
+			{Name: m.subscribers, Value: fmt.Sprint(len(event.Subscribers)), Inline: true},
+		},
+	}
+}
+
+func (d *Discord) status(s Session, i *discordgo.InteractionCreate) {
+	if wait := d.statusCooldown(interactionUserID(i), time.Now()); wait > 0 {
+		reply(s, i, fmt.Sprintf("You checked a class recently, try again in %s", wait.Round(time.Second)), true)
+		return
+	}
+	if !deferReply(s, i, false) {
+		return
+	}
+	uri := optionMap(i.ApplicationCommandData().Options)["url"].StringValue()
+	event, _, err := d.Bot.Status(uri, d.guildSettings(i).DefaultSchool)
+	if err != nil {
+		editReply(s, i, "Unable to check the class: "+explain(err))
+		interactionLogger(i).Warn("checking status failed", "event", uri, "err", err)
+		return
+	}
+	editResponse(s, i, &discordgo.WebhookEdit{
+		Embeds: []*discordgo.MessageEmbed{detailsEmbed(event, messagesFor(d.guildSettings(i).Locale))},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Subscribe", Style: discordgo.PrimaryButton, CustomID: subscribeButtonID},
+			}},
 		},
 	})
 }
+
+// statusCooldown returns how long userID must wait before checking a class at now,
+// and records the check when it may go ahead
+func (d *Discord) statusCooldown(userID string, now time.Time) time.Duration {
+	if d.StatusCooldown <= 0 {
+		return 0
+	}
+	d.alertsMu.Lock()
+	defer d.alertsMu.Unlock()
+	if last, ok := d.lastStatus[userID]; ok && now.Sub(last) < d.StatusCooldown {
+		return last.Add(d.StatusCooldown).Sub(now)
+	}
+	if d.lastStatus == nil {
+		d.lastStatus = make(map[string]time.Time)
+	}
+	for user, last := range d.lastStatus {
+		if now.Sub(last) >= d.StatusCooldown {
+			delete(d.lastStatus, user)
+		}
+	}
+	d.lastStatus[userID] = now
+	return 0
+}
+
+// subscribeButton subscribes its user to the class of the /status embed it is on
+func (d *Discord) subscribeButton(s Session, i *discordgo.InteractionCreate) {
+	if i.Message == nil || len(i.Message.Embeds) == 0 || i.Message.Embeds[0].URL == "" {
+		reply(s, i, "this message is not about a class", true)
+		return
+	}
+	if !deferReply(s, i, true) {
+		return
+	}
+	uri := i.Message.Embeds[0].URL
+	userID := interactionUserID(i)
+	event, err := d.Bot.Subscribe(uri, d.guildSettings(i).DefaultSchool, userID)
+	if err != nil {
+		editReply(s, i, "Unable to subscribe you: "+explain(err))
+		interactionLogger(i).Warn("subscribing failed", "event", uri, "err", err)
+		return
+	}
+	editReply(s, i, fmt.Sprintf("Added you to class %s", event.ClassDetails.Name))
+}
+
+func (d *Discord) preferences(s Session, i *discordgo.InteractionCreate) {
+	userID := interactionUserID(i)
+	preferences, err := d.Bot.DB.GetPreferences(userID)
+	if err != nil {
+		reply(s, i, "unable to get your preferences", true)
+		interactionLogger(i).Warn("getting preferences failed", "err", err)
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := optionMap(subcommand.Options)
+	switch subcommand.Name {
+	case "show":
+		reply(s, i, preferences.String(), true)
+		return
+	case "timezone":
+		zone := options["zone"].StringValue()
+		if _, err := time.LoadLocation(zone); err != nil || zone == "" || zone == "Local" {
+			reply(s, i, fmt.Sprintf("%s is not a timezone, use a name such as America/New_York", zone), true)
+			return
+		}
+		preferences.Timezone = zone
+	case "quiet-hours":
+		start, end := options["start"].StringValue(), options["end"].StringValue()
+		for _, clock := range []string{start, end} {
+			if _, err := parseClock(clock); err != nil {
+				reply(s, i, err.Error(), true)
+				return
+			}
+		}
+		preferences.QuietStart, preferences.QuietEnd = start, end
+	case "quiet-hours-off":
+		preferences.QuietStart, preferences.QuietEnd = "", ""
+	case "urgent":
+		transition := Transition{To: schools.ClassStatus(options["to"].StringValue())}
+		if o, ok := options["from"]; ok {
+			transition.From = schools.ClassStatus(o.StringValue())
+		}
+		for _, t := range preferences.Urgent {
+			if t == transition {
+				reply(s, i, fmt.Sprintf("%s is already urgent", transition), true)
+				return
+			}
+		}
+		preferences.Urgent = append(preferences.Urgent, transition)
+	case "urgent-clear":
+		preferences.Urgent = nil
+	case "digest":
+		preferences.Digest = options["frequency"].StringValue()
+		if preferences.Digest == "off" {
+			preferences.Digest = ""
+		}
+		if o, ok := options["at"]; ok {
+			if _, err := parseClock(o.StringValue()); err != nil {
+				reply(s, i, err.Error(), true)
+				return
+			}
+			preferences.DigestTime = o.StringValue()
+		}
+	case "immediate":
+		target := options["url"].StringValue()
+		_, uri, err := d.Bot.Schools.Resolve(target, d.guildSettings(i).DefaultSchool)
+		if err != nil {
+			reply(s, i, explain(err), true)
+			return
+		}
+		enabled := true
+		if o, ok := options["enabled"]; ok {
+			enabled = o.BoolValue()
+		}
+		immediate := make([]string, 0, len(preferences.Immediate)+1)
+		for _, u := range preferences.Immediate {
+			if u != uri {
+				immediate = append(immediate, u)
+			}
+		}
+		if enabled {
+			immediate = append(immediate, uri)
+		}
+		preferences.Immediate = immediate
+	default:
+		reply(s, i, "unknown preference", true)
+		return
+	}
+
+	if err := d.Bot.DB.SavePreferences(preferences); err != nil {
+		reply(s, i, "unable to save your preferences", true)
+		interactionLogger(i).Warn("saving preferences failed", "err", err)
+		return
+	}
+	reply(s, i, "Updated your preferences\n"+preferences.String(), true)
+}
@@ -0,0 +1,860 @@
+package class_notify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+const (
+	testGuild   = "guild"
+	testChannel = "channel"
+	testClass   = fakeSchoolURL + "cs1332"
+	otherClass  = fakeSchoolURL + "cs2110"
+)
+
+var (
+	openDetails = schools.ClassDetails{Name: "CS 1332", Status: schools.OPENED, SeatsTotal: 10, SeatsRemaining: 2}
+	fullDetails = schools.ClassDetails{Name: "CS 1332", Status: schools.FULL, SeatsTotal: 10}
+)
+
+type testBot struct {
+	discord *Discord
+	session *fakeSession
+	store   *memoryStore
+	school  *fakeSchool
+}
+
+func newTestBot(t *testing.T, events ...Event) *testBot {
+	t.Helper()
+	school := &fakeSchool{
+		details: map[string]schools.ClassDetails{
+			testClass:  openDetails,
+			otherClass: {Name: "CS 2110", Status: schools.FULL},
+		},
+		errs: make(map[string]error),
+	}
+	registry := schools.NewRegistry()
+	if err := registry.Register("TEST", school, 0); err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore(events...)
+	session := newFakeSession()
+	return &testBot{
+		discord: &Discord{
+			session:   session,
+			appID:     "bot",
+			Bot:       &Bot{Schools: registry, DB: store},
+			Operators: []string{"operator"},
+		},
+		session: session,
+		store:   store,
+		school:  school,
+	}
+}
+
+// inGuild creates interactions of userID in the test guild
+func inGuild(userID string) func(i *discordgo.Interaction) {
+	return func(i *discordgo.Interaction) {
+		i.GuildID = testGuild
+		i.ChannelID = testChannel
+		i.Member = &discordgo.Member{User: &discordgo.User{ID: userID}}
+	}
+}
+
+// inDM creates interactions of userID in its DMs with the bot
+func inDM(userID string) func(i *discordgo.Interaction) {
+	return func(i *discordgo.Interaction) {
+		i.ChannelID = "dm-" + userID
+		i.User = &discordgo.User{ID: userID}
+	}
+}
+
+func option(name string, value interface{}) *discordgo.ApplicationCommandInteractionDataOption {
+	o := &discordgo.ApplicationCommandInteractionDataOption{Name: name, Value: value}
+	switch value.(type) {
+	case string:
+		o.Type = discordgo.ApplicationCommandOptionString
+	case bool:
+		o.Type = discordgo.ApplicationCommandOptionBoolean
+	case nil:
+		o.Type = discordgo.ApplicationCommandOptionSubCommand
+	}
+	return o
+}
+
+func command(name string, from func(i *discordgo.Interaction), options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := &discordgo.Interaction{
+		ID:   "interaction",
+		Type: discordgo.InteractionApplicationCommand,
+		Data: discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}
+	from(i)
+	return &discordgo.InteractionCreate{Interaction: i}
+}
+
+func subcommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	o := option(name, nil)
+	o.Options = options
+	return o
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name       string
+		events     []Event
+		from       func(i *discordgo.Interaction)
+		url        string
+		schoolErr  error
+		reply      string
+		subscriber string
+	}{
+		{
+			name:       "guild member subscribes to a new class",
+			from:       inGuild("member"),
+			url:        testClass,
+			reply:      "Added you to class CS 1332",
+			subscriber: "member",
+		},
+		{
+			name:       "DM user subscribes to a new class",
+			from:       inDM("user"),
+			url:        testClass,
+			reply:      "Added you to class CS 1332",
+			subscriber: "user",
+		},
+		{
+			name:       "user joins a tracked class",
+			events:     []Event{{URI: testClass, School: "TEST", Subscribers: []string{"other"}, ClassDetails: fullDetails}},
+			from:       inDM("user"),
+			url:        testClass,
+			reply:      "Added you to class CS 1332",
+			subscriber: "user",
+		},
+		{
+			name:   "already subscribed",
+			events: []Event{{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails}},
+			from:   inDM("user"),
+			url:    testClass,
+			reply:  "you are already subscribed to this class",
+		},
+		{
+			name:  "url of another school",
+			from:  inDM("user"),
+			url:   "https://elsewhere.test/cs1332",
+			reply: "not the url of a class of a school served by this bot",
+		},
+		{
+			name:  "course identifier of a school without them",
+			from:  inDM("user"),
+			url:   "cs1332",
+			reply: "does not look like a class url",
+		},
+		{
+			name:      "school is down",
+			from:      inDM("user"),
+			url:       testClass,
+			schoolErr: &schools.StatusError{URI: testClass, StatusCode: 503, Status: "503 Service Unavailable"},
+			reply:     "the school's site is not responding",
+		},
+		{
+			name:  "class does not exist",
+			from:  inDM("user"),
+			url:   fakeSchoolURL + "missing",
+			reply: "the school could not find this class (404 Not Found)",
+		},
+		{
+			name:      "school changed its page",
+			from:      inDM("user"),
+			url:       testClass,
+			schoolErr: &schools.ParseError{URI: testClass, Err: errors.New("missing seats")},
+			reply:     "the class page could not be read",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, tt.events...)
+			if tt.schoolErr != nil {
+				tb.school.errs[tt.url] = tt.schoolErr
+			}
+			tb.discord.handleInteraction(command("subscribe", tt.from, option("url", tt.url)))
+
+			if !tb.session.ephemeral() {
+				t.Error("subscribe response should only be visible to its user")
+			}
+			if got := tb.session.reply(); !strings.Contains(got, tt.reply) {
+				t.Errorf("reply = %q, want it to contain %q", got, tt.reply)
+			}
+			if tt.subscriber == "" {
+				return
+			}
+			event, err := tb.store.GetEventWithURI(tt.url)
+			if err != nil {
+				t.Fatalf("event was not stored: %s", err)
+			}
+			if !contains(event.Subscribers, tt.subscriber) {
+				t.Errorf("subscribers = %v, want %s among them", event.Subscribers, tt.subscriber)
+			}
+			if event.School != "TEST" {
+				t.Errorf("school = %q, want TEST", event.School)
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		from   func(i *discordgo.Interaction)
+		reply  string
+		left   []string
+	}{
+		{
+			name:   "guild member unsubscribes",
+			events: []Event{{URI: testClass, Subscribers: []string{"member", "other"}, ClassDetails: openDetails}},
+			from:   inGuild("member"),
+			reply:  "Unsubscribed from class with name CS 1332",
+			left:   []string{"other"},
+		},
+		{
+			name:   "DM user unsubscribes",
+			events: []Event{{URI: testClass, Subscribers: []string{"user"}, ClassDetails: openDetails}},
+			from:   inDM("user"),
+			reply:  "Unsubscribed from class with name CS 1332",
+			left:   []string{},
+		},
+		{
+			name:   "not subscribed",
+			events: []Event{{URI: testClass, Subscribers: []string{"other"}, ClassDetails: openDetails}},
+			from:   inDM("user"),
+			reply:  "you are not subscribed to this class",
+			left:   []string{"other"},
+		},
+		{
+			name:  "class is not tracked",
+			from:  inDM("user"),
+			reply: "you are not subscribed to this class",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, tt.events...)
+			tb.discord.handleInteraction(command("unsubscribe", tt.from, option("url", testClass)))
+
+			if !tb.session.ephemeral() {
+				t.Error("unsubscribe response should only be visible to its user")
+			}
+			if got := tb.session.reply(); !strings.Contains(got, tt.reply) {
+				t.Errorf("reply = %q, want it to contain %q", got, tt.reply)
+			}
+			if tt.left == nil {
+				return
+			}
+			event, _ := tb.store.GetEventWithURI(testClass)
+			if strings.Join(event.Subscribers, ",") != strings.Join(tt.left, ",") {
+				t.Errorf("subscribers = %v, want %v", event.Subscribers, tt.left)
+			}
+		})
+	}
+}
+
+func TestClasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		from   func(i *discordgo.Interaction)
+		reply  []string
+	}{
+		{
+			name:  "no classes",
+			from:  inDM("user"),
+			reply: []string{"You are not subscribed to any class"},
+		},
+		{
+			name: "guild member lists their classes",
+			events: []Event{
+				{URI: testClass, Subscribers: []string{"member"}, ClassDetails: openDetails},
+				{URI: otherClass, Subscribers: []string{"other"}, ClassDetails: fullDetails},
+			},
+			from:  inGuild("member"),
+			reply: []string{"subscribed to 1 classes", "CS 1332", string(schools.OPENED)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, tt.events...)
+			tb.discord.handleInteraction(command("classes", tt.from))
+
+			if !tb.session.ephemeral() {
+				t.Error("classes response should only be visible to its user")
+			}
+			got := tb.session.reply()
+			for _, want := range tt.reply {
+				if !strings.Contains(got, want) {
+					t.Errorf("reply = %q, want it to contain %q", got, want)
+				}
+			}
+			if strings.Contains(got, otherClass) {
+				t.Errorf("reply = %q lists a class of another user", got)
+			}
+		})
+	}
+}
+
+func TestDisallowedChannel(t *testing.T) {
+	tb := newTestBot(t)
+	tb.store.SaveGuildSettings(GuildSettings{GuildID: testGuild, AllowedChannels: []string{"bot-commands"}})
+	tb.discord.handleInteraction(command("subscribe", inGuild("member"), option("url", testClass)))
+
+	if got := tb.session.reply(); got != "commands cannot be used in this channel" {
+		t.Errorf("reply = %q", got)
+	}
+	if _, err := tb.store.GetEventWithURI(testClass); !errors.Is(err, ErrNoSuchEvent) {
+		t.Errorf("event was created from a disallowed channel")
+	}
+}
+
+func TestUpdateSubscriber(t *testing.T) {
+	tb := newTestBot(t)
+	tb.session.unavailable["gone"] = true
+	event := Event{
+		URI:          testClass,
+		Subscribers:  []string{"gone", "user"},
+		Channels:     []ChannelTarget{{GuildID: testGuild, ChannelID: "alerts", RoleID: "students"}, {GuildID: testGuild, ChannelID: "quiet"}},
+		ClassDetails: openDetails,
+	}
+
+	err := tb.discord.UpdateSubscriber(Change{Event: event, Previous: fullDetails, At: time.Now()})
+	if !errors.Is(err, ErrUserUnavailable) {
+		t.Errorf("err = %v, want ErrUserUnavailable", err)
+	}
+	dm := tb.session.messages["dm-user"]
+	if len(dm) != 1 || dm[0].Embed.URL != testClass || !strings.Contains(dm[0].Embed.Title, string(schools.OPENED)) {
+		t.Errorf("DM of user = %+v, want one alert embed", dm)
+	}
+	alerts := tb.session.messages["alerts"]
+	if len(alerts) != 1 || alerts[0].Content != "<@&students>" {
+		t.Fatalf("alerts channel = %+v, want one alert pinging students", alerts)
+	}
+	if mentions := alerts[0].AllowedMentions; mentions == nil || len(mentions.Roles) != 1 || mentions.Roles[0] != "students" {
+		t.Errorf("allowed mentions = %+v, want only the students role", mentions)
+	}
+	quiet := tb.session.messages["quiet"]
+	if len(quiet) != 1 || quiet[0].Content != "" || quiet[0].AllowedMentions != nil {
+		t.Errorf("quiet channel = %+v, want one alert without pings", quiet)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("untracked class", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.discord.handleInteraction(command("status", inDM("user"), option("url", testClass)))
+
+		edits := tb.session.edits
+		if len(edits) != 1 || len(edits[0].Embeds) != 1 || edits[0].Embeds[0].URL != testClass {
+			t.Fatalf("edits = %+v, want one class embed", edits)
+		}
+		if len(edits[0].Components) != 1 {
+			t.Errorf("components = %+v, want a subscribe button", edits[0].Components)
+		}
+		if _, err := tb.store.GetEventWithURI(testClass); !errors.Is(err, ErrNoSuchEvent) {
+			t.Errorf("checking the status of a class should not track it")
+		}
+	})
+	t.Run("tracked class", func(t *testing.T) {
+		tb := newTestBot(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+		tb.discord.Bot.Debounce = Debounce{Checks: 2}
+		tb.discord.handleInteraction(command("status", inGuild("member"), option("url", testClass)))
+		tb.discord.handleInteraction(command("status", inGuild("other"), option("url", testClass)))
+
+		event, _ := tb.store.GetEventWithURI(testClass)
+		if event.ClassDetails != openDetails {
+			t.Errorf("stored details = %s, want %s", event.ClassDetails, openDetails)
+		}
+		// checks on request leave the debounce and alerts to the monitor
+		if event.Pending != nil || len(tb.session.messages["dm-user"]) != 0 {
+			t.Errorf("pending = %+v after %d alerts, want the status left to the monitor", event.Pending, len(tb.session.messages["dm-user"]))
+		}
+		if err := tb.discord.Bot.Monitor("TEST", tb.discord.UpdateSubscriber); err != nil {
+			t.Fatal(err)
+		}
+		if event, _ := tb.store.GetEventWithURI(testClass); event.Pending == nil || event.Pending.Checks != 1 {
+			t.Errorf("pending = %+v, want the first check of the monitor counted alone", event.Pending)
+		}
+	})
+	t.Run("subscribe button", func(t *testing.T) {
+		tb := newTestBot(t)
+		i := &discordgo.Interaction{
+			ID:      "button",
+			Type:    discordgo.InteractionMessageComponent,
+			Data:    discordgo.MessageComponentInteractionData{CustomID: subscribeButtonID},
+			Message: &discordgo.Message{Embeds: []*discordgo.MessageEmbed{{URL: testClass}}},
+		}
+		inGuild("member")(i)
+		tb.discord.handleInteraction(&discordgo.InteractionCreate{Interaction: i})
+
+		event, err := tb.store.GetEventWithURI(testClass)
+		if err != nil || !contains(event.Subscribers, "member") {
+			t.Errorf("button did not subscribe member: %v %v", event.Subscribers, err)
+		}
+	})
+	t.Run("cooldown", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.discord.StatusCooldown = time.Minute
+		tb.discord.handleInteraction(command("status", inGuild("member"), option("url", testClass)))
+		tb.discord.handleInteraction(command("status", inGuild("member"), option("url", otherClass)))
+
+		last := tb.session.responses[len(tb.session.responses)-1].Data
+		if len(tb.session.edits) != 1 || last == nil || !strings.Contains(last.Content, "try again in 1m0s") {
+			t.Errorf("second check = %+v after %d checks, want it refused", last, len(tb.session.edits))
+		}
+		tb.discord.handleInteraction(command("status", inGuild("other"), option("url", otherClass)))
+		if len(tb.session.edits) != 2 {
+			t.Error("the cooldown of a user held back the check of another")
+		}
+		if wait := tb.discord.statusCooldown("member", time.Now().Add(time.Minute)); wait != 0 {
+			t.Errorf("cooldown = %s once it passed", wait)
+		}
+	})
+}
+
+func TestSubscribeChannel(t *testing.T) {
+	manage := int64(discordgo.PermissionManageChannels)
+	post := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks)
+	tests := []struct {
+		name        string
+		member, bot int64
+		reply       string
+		subscribed  bool
+	}{
+		{name: "member cannot manage the channel", bot: post, reply: "you need the Manage Channels permission"},
+		{name: "bot cannot post in the channel", member: manage, reply: "I need to be able to view, send messages and embed links"},
+		{name: "channel is subscribed", member: manage, bot: post, reply: "will be posted to <#channel>", subscribed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t)
+			tb.session.permissions["member"] = map[string]int64{testChannel: tt.member}
+			tb.session.permissions["bot"] = map[string]int64{testChannel: tt.bot}
+			tb.discord.handleInteraction(command("subscribe-channel", inGuild("member"), option("url", testClass)))
+
+			if got := tb.session.reply(); !strings.Contains(got, tt.reply) {
+				t.Errorf("reply = %q, want it to contain %q", got, tt.reply)
+			}
+			event, err := tb.store.GetEventWithURI(testClass)
+			subscribed := err == nil && len(event.Channels) == 1 && event.Channels[0].ChannelID == testChannel
+			if subscribed != tt.subscribed {
+				t.Errorf("channel subscribed = %t, want %t", subscribed, tt.subscribed)
+			}
+		})
+	}
+
+	t.Run("only in guilds", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.discord.handleInteraction(command("subscribe-channel", inDM("user"), option("url", testClass)))
+		if got := tb.session.reply(); !strings.Contains(got, "can only be created in a server") {
+			t.Errorf("reply = %q", got)
+		}
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("only operators", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.discord.handleInteraction(command("admin", inDM("user"), subcommand("pause")))
+		if tb.discord.Bot.Paused() {
+			t.Error("a user who is not an operator paused monitoring")
+		}
+		if got := tb.session.reply(); !strings.Contains(got, "only operators") {
+			t.Errorf("reply = %q", got)
+		}
+	})
+	t.Run("pause and resume", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.discord.handleInteraction(command("admin", inDM("operator"), subcommand("pause")))
+		if !tb.discord.Bot.Paused() {
+			t.Error("monitoring was not paused")
+		}
+		tb.discord.handleInteraction(command("admin", inDM("operator"), subcommand("resume")))
+		if tb.discord.Bot.Paused() {
+			t.Error("monitoring was not resumed")
+		}
+	})
+	t.Run("remove event", func(t *testing.T) {
+		tb := newTestBot(t, Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: openDetails})
+		tb.discord.handleInteraction(command("admin", inDM("operator"), subcommand("remove-event", option("url", testClass))))
+		if _, err := tb.store.GetEventWithURI(testClass); !errors.Is(err, ErrNoSuchEvent) {
+			t.Errorf("event was not removed")
+		}
+	})
+	t.Run("broadcast", func(t *testing.T) {
+		tb := newTestBot(t, Event{
+			URI: testClass, School: "TEST", Subscribers: []string{"user"},
+			Channels: []ChannelTarget{{GuildID: testGuild, ChannelID: "alerts"}}, ClassDetails: openDetails,
+		})
+		tb.discord.handleInteraction(command("admin", inDM("operator"), subcommand("broadcast", option("message", "maintenance"))))
+		for _, channel := range []string{"dm-user", "alerts"} {
+			if sent := tb.session.messages[channel]; len(sent) != 1 || sent[0].Embed.Description != "maintenance" {
+				t.Errorf("%s received %+v, want the notice", channel, sent)
+			}
+		}
+	})
+}
+
+func TestConfigRequiresAdmin(t *testing.T) {
+	tb := newTestBot(t)
+	tb.discord.handleInteraction(command("config", inGuild("member"), subcommand("show")))
+	if got := tb.session.reply(); !strings.Contains(got, "you need the Manage Server permission") {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+func TestConfigLocale(t *testing.T) {
+	tb := newTestBot(t, Event{
+		URI: testClass, School: "TEST", Subscribers: []string{"user"},
+		Channels: []ChannelTarget{{GuildID: testGuild, ChannelID: "alerts"}}, ClassDetails: fullDetails,
+	})
+	admin := func(i *discordgo.Interaction) {
+		inGuild("admin")(i)
+		i.Member.Permissions = discordgo.PermissionManageServer
+	}
+	tb.discord.handleInteraction(command("config", admin, subcommand("locale", option("locale", "xx"))))
+	if got := tb.session.reply(); !strings.Contains(got, "is not a discord locale") {
+		t.Errorf("reply = %q, want the locale refused", got)
+	}
+	tb.discord.handleInteraction(command("config", admin, subcommand("locale", option("locale", "fr"))))
+	if settings, _ := tb.store.GetGuildSettings(testGuild); settings.Locale != "fr" {
+		t.Fatalf("locale = %q, want fr", settings.Locale)
+	}
+
+	tb.discord.handleInteraction(command("status", inGuild("member"), option("url", testClass)))
+	edits := tb.session.edits
+	if embeds := edits[len(edits)-1].Embeds; len(embeds) != 1 || embeds[0].Fields[0].Name != "Statut" || embeds[0].Fields[0].Value != "OUVERT" {
+		t.Errorf("status reply = %+v, want it in french", embeds)
+	}
+	event, _ := tb.store.GetEventWithURI(testClass)
+	event.ClassDetails = openDetails
+	if err := tb.discord.UpdateSubscriber(Change{Event: event, Previous: fullDetails, At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if alerts := tb.session.messages["alerts"]; len(alerts) == 0 || alerts[len(alerts)-1].Embed.Title != "LE STATUT DU COURS EST PASSÉ À OUVERT" {
+		t.Errorf("channel alerts = %+v, want them in french", alerts)
+	}
+	if dm := tb.session.messages["dm-user"]; len(dm) == 0 || dm[len(dm)-1].Embed.Title != "CLASS STATUS HAS CHANGED TO OPENED" {
+		t.Errorf("DMs = %+v, want them in english", dm)
+	}
+}
+
+func TestSyncCommands(t *testing.T) {
+	tb := newTestBot(t)
+	changed, err := tb.discord.SyncCommands(testGuild)
+	if err != nil || !changed {
+		t.Fatalf("first sync = %t, %v, want commands to be created", changed, err)
+	}
+	changed, err = tb.discord.SyncCommands(testGuild)
+	if err != nil || changed {
+		t.Errorf("second sync = %t, %v, want nothing to change", changed, err)
+	}
+	if tb.session.overwrites != 1 {
+		t.Errorf("overwrites = %d, want 1", tb.session.overwrites)
+	}
+	if err := tb.discord.PurgeCommands(testGuild); err != nil || len(tb.session.commands[testGuild]) != 0 {
+		t.Errorf("purge left %d commands: %v", len(tb.session.commands[testGuild]), err)
+	}
+}
+
+func TestQuietHoursQueue(t *testing.T) {
+	tb := newTestBot(t)
+	// quiet all day but the last minute
+	tb.store.SavePreferences(Preferences{UserID: "sleeper", QuietStart: "00:00", QuietEnd: "23:59"})
+	tb.store.SavePreferences(Preferences{
+		UserID: "eager", QuietStart: "00:00", QuietEnd: "23:59",
+		Urgent: []Transition{{From: schools.FULL, To: schools.OPENED}},
+	})
+	event := Event{URI: testClass, Subscribers: []string{"sleeper", "eager", "awake"}}
+	now := time.Now()
+	if tb.store.preferences["sleeper"].InQuietHours(now) == false {
+		t.Skip("test is running during the last minute of the day")
+	}
+
+	opened := event
+	opened.ClassDetails = openDetails
+	if err := tb.discord.UpdateSubscriber(Change{Event: opened, Previous: fullDetails, At: now}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tb.session.messages["dm-sleeper"]) != 0 {
+		t.Error("alert was sent during quiet hours")
+	}
+	for _, user := range []string{"eager", "awake"} {
+		if len(tb.session.messages["dm-"+user]) != 1 {
+			t.Errorf("%s did not get the alert right away", user)
+		}
+	}
+	if len(tb.store.queue) != 1 || tb.store.queue[0].UserID != "sleeper" {
+		t.Fatalf("queue = %+v, want one alert for sleeper", tb.store.queue)
+	}
+
+	if err := tb.discord.DeliverQueued(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(tb.session.messages["dm-sleeper"]) != 0 {
+		t.Error("alert was delivered before the quiet hours ended")
+	}
+	if err := tb.discord.DeliverQueued(tb.store.queue[0].DeliverAt); err != nil {
+		t.Fatal(err)
+	}
+	sent := tb.session.messages["dm-sleeper"]
+	if len(sent) != 1 || !strings.Contains(sent[0].Embed.Footer.Text, "quiet hours") {
+		t.Errorf("delivered = %+v, want one alert held during quiet hours", sent)
+	}
+	if len(tb.store.queue) != 0 {
+		t.Errorf("queue = %+v, want it emptied", tb.store.queue)
+	}
+}
+
+func TestQueuedAlertsAreMerged(t *testing.T) {
+	tb := newTestBot(t)
+	deliverAt := time.Now()
+	full, opened := Event{URI: testClass, ClassDetails: fullDetails}, Event{URI: testClass, ClassDetails: openDetails}
+	other := Event{URI: otherClass, ClassDetails: schools.ClassDetails{Name: "CS 2110", Status: schools.WAITLISTED}}
+	for i, change := range []Change{
+		{Event: opened, Previous: fullDetails},
+		{Event: full, Previous: openDetails},
+		{Event: other, Previous: schools.ClassDetails{Status: schools.FULL}},
+	} {
+		change.At = deliverAt.Add(time.Duration(i-10) * time.Minute)
+		tb.store.QueueAlert(QueuedAlert{UserID: "user", Change: change, DeliverAt: deliverAt})
+	}
+
+	if err := tb.discord.DeliverQueued(deliverAt); err != nil {
+		t.Fatal(err)
+	}
+	sent := tb.session.messages["dm-user"]
+	if len(sent) != 1 || sent[0].Embed.URL != otherClass {
+		t.Errorf("delivered = %+v, want only the alert of the class that did not go back to its status", sent)
+	}
+}
+
+func TestQueuedAlertsSurviveFailures(t *testing.T) {
+	now := time.Now()
+	opened := Change{Event: Event{URI: testClass, ClassDetails: openDetails}, Previous: fullDetails, At: now}
+	tests := []struct {
+		name  string
+		alert QueuedAlert
+	}{
+		{"held for quiet hours", QueuedAlert{UserID: "user", Change: opened, DeliverAt: now, Reason: HeldForQuietHours}},
+		{"digest", QueuedAlert{UserID: "user", Change: opened, DeliverAt: now, Digest: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t)
+			tb.store.QueueAlert(tt.alert)
+
+			tb.session.sendErr = errors.New("discord is down")
+			if err := tb.discord.DeliverQueued(now); err == nil {
+				t.Error("failing to send the alert was not reported")
+			}
+			if len(tb.store.queue) != 1 {
+				t.Fatalf("queue = %+v after failing to send, want the alert kept", tb.store.queue)
+			}
+
+			tb.session.sendErr = nil
+			if err := tb.discord.DeliverQueued(now.Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if len(tb.session.messages["dm-user"]) != 1 || len(tb.store.queue) != 0 {
+				t.Errorf("sent %d alerts leaving %d queued, want the alert sent on the next delivery",
+					len(tb.session.messages["dm-user"]), len(tb.store.queue))
+			}
+		})
+	}
+
+	t.Run("delivery that never finished", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.store.QueueAlert(QueuedAlert{UserID: "user", Change: opened, DeliverAt: now})
+		// a delivery claims the alert and crashes before sending it
+		tb.store.ClaimDueAlerts(now, queueLease)
+
+		tb.discord.DeliverQueued(now.Add(time.Minute))
+		if len(tb.session.messages["dm-user"]) != 0 {
+			t.Error("alert claimed by another delivery was sent")
+		}
+		tb.discord.DeliverQueued(now.Add(queueLease))
+		if len(tb.session.messages["dm-user"]) != 1 || len(tb.store.queue) != 0 {
+			t.Error("alert was not sent once the lease of the crashed delivery ran out")
+		}
+	})
+
+	t.Run("user without dms", func(t *testing.T) {
+		tb := newTestBot(t)
+		tb.session.unavailable["user"] = true
+		tb.store.QueueAlert(QueuedAlert{UserID: "user", Change: opened, DeliverAt: now})
+		tb.discord.DeliverQueued(now)
+		if len(tb.store.queue) != 0 {
+			t.Error("alert of a user who cannot be sent dms was kept")
+		}
+	})
+}
+
+func TestPreferencesCommand(t *testing.T) {
+	tb := newTestBot(t)
+	for _, sub := range []*discordgo.ApplicationCommandInteractionDataOption{
+		subcommand("timezone", option("zone", "America/Chicago")),
+		subcommand("quiet-hours", option("start", "23:00"), option("end", "08:30")),
+		subcommand("urgent", option("to", "OPENED"), option("from", "FULL")),
+	} {
+		tb.discord.handleInteraction(command("preferences", inDM("user"), sub))
+	}
+	want := Preferences{
+		UserID: "user", Timezone: "America/Chicago", QuietStart: "23:00", QuietEnd: "08:30",
+		Urgent: []Transition{{From: schools.FULL, To: schools.OPENED}},
+	}
+	got := tb.store.preferences["user"]
+	if got.String() != want.String() {
+		t.Errorf("preferences = %s, want %s", got, want)
+	}
+
+	for _, sub := range []*discordgo.ApplicationCommandInteractionDataOption{
+		subcommand("timezone", option("zone", "Mars/Olympus")),
+		subcommand("quiet-hours", option("start", "late"), option("end", "08:30")),
+	} {
+		tb.discord.handleInteraction(command("preferences", inDM("user"), sub))
+		if got := tb.session.reply(); strings.HasPrefix(got, "Updated") {
+			t.Errorf("invalid %s was saved: %q", sub.Name, got)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tb := newTestBot(t)
+	for _, sub := range []*discordgo.ApplicationCommandInteractionDataOption{
+		subcommand("digest", option("frequency", DigestHourly)),
+		subcommand("immediate", option("url", otherClass)),
+	} {
+		tb.discord.handleInteraction(command("preferences", inDM("user"), sub))
+	}
+	if got := tb.store.preferences["user"]; got.Digest != DigestHourly || len(got.Immediate) != 1 || got.Immediate[0] != otherClass {
+		t.Fatalf("preferences = %+v, want an hourly digest with %s alerted right away", got, otherClass)
+	}
+
+	now := time.Now()
+	opened := Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: openDetails}
+	full := Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: schools.ClassDetails{Name: "CS 1332", Status: schools.FULL, SeatsTotal: 10}}
+	waitlisted := Event{URI: otherClass, Subscribers: []string{"user"}, ClassDetails: schools.ClassDetails{Name: "CS 2110", Status: schools.WAITLISTED}}
+	for _, change := range []Change{
+		{Event: opened, Previous: schools.ClassDetails{Status: schools.FULL, SeatsTotal: 10}, At: now},
+		{Event: full, Previous: openDetails, At: now.Add(time.Second)},
+		{Event: opened, Previous: schools.ClassDetails{Status: schools.FULL, SeatsTotal: 10}, At: now.Add(2 * time.Second)},
+		{Event: waitlisted, Previous: schools.ClassDetails{Status: schools.FULL}, At: now},
+	} {
+		if err := tb.discord.UpdateSubscriber(change); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := tb.session.messages["dm-user"]
+	if len(sent) != 1 || sent[0].Embed.URL != otherClass {
+		t.Fatalf("sent = %+v, want only the alert of the immediate class", sent)
+	}
+	if len(tb.store.queue) != 3 {
+		t.Fatalf("queue holds %d alerts, want the 3 digest alerts", len(tb.store.queue))
+	}
+	if err := tb.discord.DeliverQueued(tb.store.queue[0].DeliverAt); err != nil {
+		t.Fatal(err)
+	}
+	sent = tb.session.messages["dm-user"]
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the immediate alert and one digest", len(sent))
+	}
+	digest := sent[1].Embed
+	if len(digest.Fields) != 1 {
+		t.Fatalf("digest fields = %+v, want one class", digest.Fields)
+	}
+	for _, want := range []string{string(schools.OPENED), "was FULL", "changed 3 times", "seats +2"} {
+		if !strings.Contains(digest.Fields[0].Value, want) {
+			t.Errorf("digest field = %q, want it to contain %q", digest.Fields[0].Value, want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tb := newTestBot(t)
+	tb.discord.RateLimit = 10 * time.Minute
+	now := time.Now()
+	full, opened := Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: fullDetails}, Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: openDetails}
+	waitlisted := full
+	waitlisted.ClassDetails.Status = schools.WAITLISTED
+
+	for i, change := range []Change{
+		{Event: opened, Previous: fullDetails},
+		{Event: full, Previous: openDetails},
+		{Event: waitlisted, Previous: fullDetails},
+	} {
+		change.At = now.Add(time.Duration(i) * time.Minute)
+		if err := tb.discord.UpdateSubscriber(change); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := tb.session.messages["dm-user"]; len(sent) != 1 {
+		t.Fatalf("sent = %d alerts, want only the first one before the rate limit", len(sent))
+	}
+	if len(tb.store.queue) != 2 || tb.store.queue[0].Reason != HeldForRateLimit {
+		t.Fatalf("queue = %+v, want the later alerts held by the rate limit", tb.store.queue)
+	}
+
+	if err := tb.discord.DeliverQueued(tb.store.queue[0].DeliverAt); err != nil {
+		t.Fatal(err)
+	}
+	sent := tb.session.messages["dm-user"]
+	if len(sent) != 2 || sent[1].Embed.Footer.Text != "was OPENED, changed 2 times since your last alert" {
+		t.Errorf("delivered = %+v, want one alert merging the held changes", sent)
+	}
+}
+
+func TestRateLimitAfterFailedAlert(t *testing.T) {
+	tb := newTestBot(t)
+	tb.discord.RateLimit = 10 * time.Minute
+	opened := Event{URI: testClass, Subscribers: []string{"user"}, ClassDetails: openDetails}
+	change := Change{Event: opened, Previous: fullDetails, At: time.Now()}
+
+	tb.session.sendErr = errors.New("discord is down")
+	if err := tb.discord.UpdateSubscriber(change); err == nil {
+		t.Fatal("UpdateSubscriber() = nil, want the error of the failed alert")
+	}
+	tb.session.sendErr = nil
+	if err := tb.discord.UpdateSubscriber(change); err != nil {
+		t.Fatal(err)
+	}
+	if sent := tb.session.messages["dm-user"]; len(sent) != 1 || len(tb.store.queue) != 0 {
+		t.Errorf("sent = %d alerts, queued %d, want the retry sent as the failed alert counts against no limit", len(sent), len(tb.store.queue))
+	}
+}
+
+func TestRateLimitForgetsOldAlerts(t *testing.T) {
+	tb := newTestBot(t)
+	tb.discord.RateLimit = 10 * time.Minute
+	now := time.Now()
+	tb.discord.alerted("user", testClass, now.Add(-time.Hour))
+	tb.discord.alerted("user", otherClass, now)
+	if _, ok := tb.discord.lastAlerts[userEvent{"user", testClass}]; ok || len(tb.discord.lastAlerts) != 1 {
+		t.Errorf("last alerts = %v, want only the one the rate limit still holds back", tb.discord.lastAlerts)
+	}
+}
+
+func TestBriefStatusEmbed(t *testing.T) {
+	change := Change{
+		Event:    Event{URI: testClass, ClassDetails: fullDetails},
+		Previous: fullDetails,
+		At:       time.Now(),
+		Brief:    &Brief{Status: schools.OPENED, Since: time.Now().Add(-90 * time.Second), Duration: 90 * time.Second},
+	}
+	embed := statusEmbed(change, english)
+	if embed.Title != "CLASS WAS BRIEFLY OPENED" || !strings.Contains(embed.Description, "1m30s") {
+		t.Errorf("embed = %q %q, want a summary of the briefly open seat", embed.Title, embed.Description)
+	}
+}
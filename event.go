@@ -3,12 +3,57 @@ package class_notify
 import (
 	"encoding/json"
 	"github.com/zMrKrabz/class-notify/schools"
+	"time"
 )
 
 type Event struct {
-	URI         string               `bson:"uri"`
+	URI          string               `bson:"uri"`
+	School       string               `bson:"school"`
 	Subscribers  []string             `bson:"subscribers"`
+	Channels     []ChannelTarget      `bson:"channels"`
 	ClassDetails schools.ClassDetails `bson:"class_details"`
+	// Notified holds the details subscribers were last alerted of, events stored
+	// before it was tracked were alerted of their ClassDetails
+	Notified schools.ClassDetails `bson:"notified"`
+	// Pending is a new status that has not lasted long enough to be alerted
+	Pending *Pending `bson:"pending,omitempty"`
+}
+
+// Pending is a status of a class waiting to be alerted until it settles
+type Pending struct {
+	Status schools.ClassStatus `bson:"status"`
+	Since  time.Time           `bson:"since"`
+	Checks int                 `bson:"checks"`
+}
+
+// Brief is a status a class went back from before it settled, such as a seat
+// that opened and was taken again
+type Brief struct {
+	Status   schools.ClassStatus `bson:"status" json:"status"`
+	Since    time.Time           `bson:"since" json:"since"`
+	Duration time.Duration       `bson:"duration" json:"duration"`
+}
+
+// ChannelTarget is a server channel alerts of an event are posted to, optionally
+// pinging a role
+type ChannelTarget struct {
+	GuildID   string `bson:"guild_id"`
+	ChannelID string `bson:"channel_id"`
+	RoleID    string `bson:"role_id,omitempty"`
+}
+
+// Change is a new status of the class of an event, detected by the monitor
+type Change struct {
+	// Event holds the current details of the class
+	Event    Event                `bson:"event"`
+	Previous schools.ClassDetails `bson:"previous"`
+	At       time.Time            `bson:"at"`
+	// Brief is set when the class went back to the status its subscribers know of,
+	// which is Previous
+	Brief *Brief `bson:"brief,omitempty"`
+	// CheckID correlates the logs of the check that found the change with those
+	// of its alerts
+	CheckID string `bson:"check_id"`
 }
 
 func (e Event) String() string {
@@ -17,4 +62,4 @@ func (e Event) String() string {
 		return ""
 	}
 	return string(b)
-}
\ No newline at end of file
+}
@@ -0,0 +1,495 @@
+package class_notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// fakeSession records what the bot sends to discord
+type fakeSession struct {
+	mu sync.Mutex
+	// responses are the initial responses to interactions, edits their follow ups
+	responses []*discordgo.InteractionResponse
+	edits     []*discordgo.WebhookEdit
+	// messages holds the messages sent to every channel, DM channels are named
+	// dm-<user id>
+	messages map[string][]*discordgo.MessageSend
+	// unavailable users cannot be sent DMs
+	unavailable map[string]bool
+	// sendErr, when set, fails every message sent
+	sendErr error
+	// permissions of users in channels, by user id then channel id
+	permissions map[string]map[string]int64
+	commands    map[string][]*discordgo.ApplicationCommand
+	overwrites  int
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{
+		messages:    make(map[string][]*discordgo.MessageSend),
+		unavailable: make(map[string]bool),
+		permissions: make(map[string]map[string]int64),
+		commands:    make(map[string][]*discordgo.ApplicationCommand),
+	}
+}
+
+func (f *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, resp)
+	return nil
+}
+
+func (f *fakeSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, newresp)
+	return &discordgo.Message{Content: newresp.Content}, nil
+}
+
+func (f *fakeSession) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	if f.unavailable[recipientID] {
+		return nil, errors.New("cannot send messages to this user")
+	}
+	return &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (f *fakeSession) UserChannelPermissions(userID string, channelID string) (int64, error) {
+	return f.permissions[userID][channelID], nil
+}
+
+func (f *fakeSession) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return f.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Embed: embed})
+}
+
+func (f *fakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.messages[channelID] = append(f.messages[channelID], data)
+	return &discordgo.Message{ChannelID: channelID, Content: data.Content}, nil
+}
+
+func (f *fakeSession) ApplicationCommands(appID string, guildID string) ([]*discordgo.ApplicationCommand, error) {
+	return f.commands[guildID], nil
+}
+
+func (f *fakeSession) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand) ([]*discordgo.ApplicationCommand, error) {
+	f.overwrites++
+	f.commands[guildID] = commands
+	return commands, nil
+}
+
+func (f *fakeSession) UserGuilds(limit int, beforeID string, afterID string) ([]*discordgo.UserGuild, error) {
+	return nil, nil
+}
+
+// reply returns the text the user last saw in answer to an interaction
+func (f *fakeSession) reply() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.edits) > 0 {
+		return f.edits[len(f.edits)-1].Content
+	}
+	if len(f.responses) > 0 && f.responses[len(f.responses)-1].Data != nil {
+		return f.responses[len(f.responses)-1].Data.Content
+	}
+	return ""
+}
+
+// ephemeral reports whether the first response to an interaction was only shown
+// to its user
+func (f *fakeSession) ephemeral() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.responses) > 0 && f.responses[0].Data != nil &&
+		f.responses[0].Data.Flags&uint64(discordgo.MessageFlagsEphemeral) != 0
+}
+
+// memoryStore keeps events and settings in memory, mirroring the behavior of
+// Database
+type memoryStore struct {
+	mu          sync.Mutex
+	events      map[string]Event
+	guilds      map[string]GuildSettings
+	preferences map[string]Preferences
+	queue       []QueuedAlert
+	queued      int
+	// claims holds the end of the lease of claimed alerts
+	claims  map[string]time.Time
+	pingErr error
+	// writeErr, when set, fails every write of events and preferences
+	writeErr error
+	history  []HistoryEntry
+}
+
+func newMemoryStore(events ...Event) *memoryStore {
+	m := &memoryStore{
+		events:      make(map[string]Event),
+		guilds:      make(map[string]GuildSettings),
+		preferences: make(map[string]Preferences),
+		claims:      make(map[string]time.Time),
+	}
+	for _, e := range events {
+		m.events[e.URI] = e
+	}
+	return m
+}
+
+func (m *memoryStore) active(school string, isDefault bool) []Event {
+	var events []Event
+	for _, e := range m.events {
+		if e.ClassDetails.Status == schools.COMPLETED {
+			continue
+		}
+		if e.School == school || (isDefault && e.School == "") {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (m *memoryStore) GetAllActiveEvents(school string, isDefault bool) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active(school, isDefault), nil
+}
+
+func (m *memoryStore) GetActiveEventsCount(school string, isDefault bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.active(school, isDefault))), nil
+}
+
+func (m *memoryStore) GetActiveSubscribersCount(school string, isDefault bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for _, e := range m.active(school, isDefault) {
+		count += int64(len(e.Subscribers))
+	}
+	return count, nil
+}
+
+func (m *memoryStore) GetEventWithURI(uri string) (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.events[uri]
+	if !ok {
+		return Event{}, ErrNoSuchEvent
+	}
+	return e, nil
+}
+
+func (m *memoryStore) GetEventsWithSubscriber(userID string) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []Event
+	for _, e := range m.events {
+		if contains(e.Subscribers, userID) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (m *memoryStore) CreateEvent(event Event) (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.writeErr != nil {
+		return Event{}, m.writeErr
+	}
+	if _, ok := m.events[event.URI]; ok {
+		return Event{}, fmt.Errorf("event %s already exists", event.URI)
+	}
+	m.events[event.URI] = event
+	return event, nil
+}
+
+func (m *memoryStore) update(uri string, fn func(e *Event) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	e, ok := m.events[uri]
+	if !ok {
+		return errors.New("unable to match any events with uri: " + uri)
+	}
+	if err := fn(&e); err != nil {
+		return err
+	}
+	m.events[uri] = e
+	return nil
+}
+
+func (m *memoryStore) AddSubscriber(uri string, subscriberID string) error {
+	return m.update(uri, func(e *Event) error {
+		if contains(e.Subscribers, subscriberID) {
+			return ErrAlreadySubscribed
+		}
+		e.Subscribers = append(e.Subscribers, subscriberID)
+		return nil
+	})
+}
+
+func (m *memoryStore) RemoveSubscriber(uri string, subscriberID string) error {
+	return m.update(uri, func(e *Event) error {
+		if !contains(e.Subscribers, subscriberID) {
+			return ErrNotSubscribed
+		}
+		subscribers := make([]string, 0, len(e.Subscribers))
+		for _, s := range e.Subscribers {
+			if s != subscriberID {
+				subscribers = append(subscribers, s)
+			}
+		}
+		e.Subscribers = subscribers
+		return nil
+	})
+}
+
+func (m *memoryStore) AddChannel(uri string, target ChannelTarget) error {
+	if err := m.RemoveChannel(uri, target.GuildID, target.ChannelID); err != nil && !errors.Is(err, ErrNoSuchChannel) {
+		return err
+	}
+	return m.update(uri, func(e *Event) error {
+		e.Channels = append(e.Channels, target)
+		return nil
+	})
+}
+
+func (m *memoryStore) RemoveChannel(uri string, guildID string, channelID string) error {
+	return m.update(uri, func(e *Event) error {
+		channels := make([]ChannelTarget, 0, len(e.Channels))
+		for _, c := range e.Channels {
+			if c.GuildID != guildID || c.ChannelID != channelID {
+				channels = append(channels, c)
+			}
+		}
+		if len(channels) == len(e.Channels) {
+			return ErrNoSuchChannel
+		}
+		e.Channels = channels
+		return nil
+	})
+}
+
+func (m *memoryStore) RemoveEvent(uri string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.events[uri]; !ok {
+		return errors.New("failed to delete event with uri " + uri)
+	}
+	delete(m.events, uri)
+	return nil
+}
+
+func (m *memoryStore) UpdateEventDetails(uri string, details schools.ClassDetails) error {
+	return m.update(uri, func(e *Event) error {
+		e.ClassDetails = details
+		return nil
+	})
+}
+
+func (m *memoryStore) UpdateEventNotification(uri string, notified schools.ClassDetails, pending *Pending) error {
+	return m.update(uri, func(e *Event) error {
+		e.Notified = notified
+		e.Pending = pending
+		return nil
+	})
+}
+
+func (m *memoryStore) GetGuildSettings(guildID string) (GuildSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if settings, ok := m.guilds[guildID]; ok {
+		return settings, nil
+	}
+	return GuildSettings{GuildID: guildID}, nil
+}
+
+func (m *memoryStore) SaveGuildSettings(settings GuildSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.guilds[settings.GuildID] = settings
+	return nil
+}
+
+func (m *memoryStore) GetPreferences(userID string) (Preferences, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if preferences, ok := m.preferences[userID]; ok {
+		return preferences, nil
+	}
+	return Preferences{UserID: userID}, nil
+}
+
+func (m *memoryStore) SavePreferences(preferences Preferences) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	m.preferences[preferences.UserID] = preferences
+	return nil
+}
+
+func (m *memoryStore) GetPreferencesWithFeedToken(token string) (Preferences, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, preferences := range m.preferences {
+		if token != "" && preferences.FeedToken == token {
+			return preferences, nil
+		}
+	}
+	return Preferences{}, ErrNoSuchFeed
+}
+
+func (m *memoryStore) QueueAlert(alert QueuedAlert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued++
+	alert.ID = fmt.Sprintf("%08d", m.queued)
+	m.queue = append(m.queue, alert)
+	return nil
+}
+
+func (m *memoryStore) GetQueuedAlertsCount() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.queue)), nil
+}
+
+func (m *memoryStore) AddHistory(entry HistoryEntry) (HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.ID = fmt.Sprintf("%08d", len(m.history)+1)
+	m.history = append(m.history, entry)
+	return entry, nil
+}
+
+func (m *memoryStore) GetHistory(filter HistoryFilter) ([]HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]HistoryEntry, 0)
+	for _, entry := range m.history {
+		matches := entry.URI == filter.URI || filter.URI == "" && (len(filter.URIs) == 0 || contains(filter.URIs, entry.URI))
+		matches = matches && StreamFilter{Schools: filter.Schools, Courses: filter.Courses}.Matches(entry)
+		if matches && entry.ID > filter.AfterID {
+			entries = append(entries, entry)
+		}
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		if filter.AfterID != "" {
+			entries = entries[:filter.Limit]
+		} else {
+			entries = entries[len(entries)-filter.Limit:]
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) Ping() error {
+	return m.pingErr
+}
+
+func (m *memoryStore) ClaimDueAlerts(now time.Time, lease time.Duration) ([]QueuedAlert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []QueuedAlert
+	for _, alert := range m.queue {
+		if !alert.DeliverAt.After(now) && !m.claims[alert.ID].After(now) {
+			m.claims[alert.ID] = now.Add(lease)
+			due = append(due, alert)
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryStore) AckAlerts(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var left []QueuedAlert
+	for _, alert := range m.queue {
+		if !contains(ids, alert.ID) {
+			left = append(left, alert)
+		}
+	}
+	m.queue = left
+	return nil
+}
+
+func (m *memoryStore) ReleaseAlerts(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.claims, id)
+	}
+	return nil
+}
+
+// fakeSchool serves the details of classes under https://school.test/
+type fakeSchool struct {
+	details map[string]schools.ClassDetails
+	errs    map[string]error
+}
+
+const fakeSchoolURL = "https://school.test/"
+
+func (fs *fakeSchool) GetClassDetails(uri string) (schools.ClassDetails, error) {
+	if err, ok := fs.errs[uri]; ok {
+		return schools.ClassDetails{}, err
+	}
+	details, ok := fs.details[uri]
+	if !ok {
+		return schools.ClassDetails{}, &schools.StatusError{URI: uri, StatusCode: 404, Status: "404 Not Found"}
+	}
+	return details, nil
+}
+
+func (fs *fakeSchool) Matches(uri string) bool {
+	return strings.HasPrefix(uri, fakeSchoolURL)
+}
+
+// fakeBatchSchool checks every class of fakeSchool with a single listing, leaving
+// out the unlisted ones
+type fakeBatchSchool struct {
+	*fakeSchool
+	unlisted map[string]bool
+	batchErr error
+	// batches and singles are the uris checked with a listing and on their own
+	batches [][]string
+	singles []string
+}
+
+func (fs *fakeBatchSchool) GetClassDetails(uri string) (schools.ClassDetails, error) {
+	fs.singles = append(fs.singles, uri)
+	return fs.fakeSchool.GetClassDetails(uri)
+}
+
+func (fs *fakeBatchSchool) BatchKey(uri string) string {
+	return "listing"
+}
+
+func (fs *fakeBatchSchool) GetManyClassDetails(uris []string) (map[string]schools.ClassDetails, error) {
+	fs.batches = append(fs.batches, uris)
+	if fs.batchErr != nil {
+		return nil, fs.batchErr
+	}
+	details := make(map[string]schools.ClassDetails)
+	for _, uri := range uris {
+		if d, ok := fs.details[uri]; ok && !fs.unlisted[uri] {
+			details[uri] = d
+		}
+	}
+	return details, nil
+}
@@ -0,0 +1,216 @@
+package class_notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Feeds serves Atom feeds of the status changes of a class, and of every class of
+// a user behind the secret token of their feed
+type Feeds struct {
+	Bot *Bot
+	// PublicURL is where users reach the http server, such as
+	// https://alerts.example.com, the host of each request when empty
+	PublicURL string
+	// MaxAge is how long feed readers may reuse a feed before fetching it again
+	MaxAge time.Duration
+}
+
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated time.Time   `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Updated time.Time `xml:"updated"`
+	Link    atomLink  `xml:"link"`
+	Summary string    `xml:"summary"`
+}
+
+// Handler routes the class feeds on /feeds/classes?class=<url> and the feeds of
+// users on /feeds/users/<token>
+func (f *Feeds) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /feeds/classes", f.class)
+	mux.HandleFunc("GET /feeds/users/{token}", f.user)
+	return mux
+}
+
+func (f *Feeds) class(w http.ResponseWriter, r *http.Request) {
+	class := r.URL.Query().Get("class")
+	if class == "" {
+		http.Error(w, `the "class" query parameter is required`, http.StatusBadRequest)
+		return
+	}
+	event, err := f.Bot.Event(class, r.URL.Query().Get("school"))
+	if err != nil {
+		writeFeedError(w, err)
+		return
+	}
+	entries, err := f.Bot.DB.GetHistory(HistoryFilter{URI: event.URI, Limit: feedEntries})
+	if err != nil {
+		slog.Warn("getting feed history failed", "event", event.URI, "err", err)
+		writeFeedError(w, err)
+		return
+	}
+	f.serveFeed(w, r, "public", atomFeed{
+		ID:    classFeedURL(f.baseURL(r), event.URI),
+		Title: fmt.Sprintf("%s status changes", event.ClassDetails.Name),
+		Links: []atomLink{{Rel: "alternate", Href: event.URI}},
+	}, entries)
+}
+
+func (f *Feeds) user(w http.ResponseWriter, r *http.Request) {
+	preferences, err := f.Bot.DB.GetPreferencesWithFeedToken(r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, ErrNoSuchFeed) {
+			http.Error(w, "no feed exists with this token", http.StatusNotFound)
+			return
+		}
+		slog.Warn("getting feed token failed", "err", err)
+		writeFeedError(w, err)
+		return
+	}
+	events, err := f.Bot.GetUserEvents(preferences.UserID)
+	if err != nil {
+		slog.Warn("getting feed classes failed", userKey, preferences.UserID, "err", err)
+		writeFeedError(w, err)
+		return
+	}
+	var entries []HistoryEntry
+	if len(events) > 0 {
+		uris := make([]string, len(events))
+		for i, event := range events {
+			uris[i] = event.URI
+		}
+		if entries, err = f.Bot.DB.GetHistory(HistoryFilter{URIs: uris, Limit: feedEntries}); err != nil {
+			slog.Warn("getting feed history failed", userKey, preferences.UserID, "err", err)
+			writeFeedError(w, err)
+			return
+		}
+	}
+	f.serveFeed(w, r, "private", atomFeed{
+		ID:    userFeedURL(f.baseURL(r), preferences.FeedToken),
+		Title: fmt.Sprintf("Status changes of your %d classes", len(events)),
+	}, entries)
+}
+
+// serveFeed writes feed with entries newest first. Its ETag is a hash of the feed
+// and its Last-Modified time the one of the latest entry, so that readers polling
+// an unchanged feed get a 304.
+func (f *Feeds) serveFeed(w http.ResponseWriter, r *http.Request, cache string, feed atomFeed, entries []HistoryEntry) {
+	feed.Author = atomAuthor{Name: "class-notify"}
+	feed.Links = append(feed.Links, atomLink{Rel: "self", Href: feed.ID})
+	feed.Updated = processStarted.UTC().Truncate(time.Second)
+	if len(entries) > 0 {
+		feed.Updated = entries[len(entries)-1].At.UTC().Truncate(time.Second)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		feed.Entries = append(feed.Entries, newAtomEntry(entries[i]))
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	if err := xml.NewEncoder(&b).Encode(feed); err != nil {
+		writeFeedError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cache, int(f.MaxAge.Seconds())))
+	sum := sha256.Sum256(b.Bytes())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:12])+`"`)
+	http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(b.Bytes()))
+}
+
+func newAtomEntry(entry HistoryEntry) atomEntry {
+	title := fmt.Sprintf("%s is %s", entry.Name, entry.Status)
+	if entry.Brief != nil {
+		title = fmt.Sprintf("%s was briefly %s", entry.Name, entry.Status)
+	}
+	summary := fmt.Sprintf("%d of %d seats and %d of %d waitlist spots remaining",
+		entry.SeatsRemaining, entry.SeatsTotal, entry.WaitlistRemaining, entry.WaitlistTotal)
+	if entry.Brief != nil {
+		summary = fmt.Sprintf("%s for %s, %s", entry.Status, entry.Brief.Duration.Round(time.Second), summary)
+	} else if entry.Previous != "" {
+		summary = fmt.Sprintf("Was %s, %s", entry.Previous, summary)
+	}
+	return atomEntry{
+		ID:      "urn:class-notify:history:" + entry.ID,
+		Title:   title,
+		Updated: entry.At.UTC(),
+		Link:    atomLink{Rel: "alternate", Href: entry.URI},
+		Summary: summary,
+	}
+}
+
+func writeFeedError(w http.ResponseWriter, err error) {
+	http.Error(w, explain(err), errorStatus(err))
+}
+
+// baseURL returns the url the feeds are reached at
+func (f *Feeds) baseURL(r *http.Request) string {
+	if f.PublicURL != "" {
+		return strings.TrimSuffix(f.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func classFeedURL(base string, uri string) string {
+	return base + "/feeds/classes?class=" + url.QueryEscape(uri)
+}
+
+func userFeedURL(base string, token string) string {
+	return base + "/feeds/users/" + token
+}
+
+// FeedToken returns the token of the feed of userID, creating it the first time
+func (bot *Bot) FeedToken(userID string) (string, error) {
+	preferences, err := bot.DB.GetPreferences(userID)
+	if err != nil {
+		return "", fmt.Errorf("getting preferences: %w", err)
+	}
+	if preferences.FeedToken != "" {
+		return preferences.FeedToken, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating feed token: %s", err)
+	}
+	preferences.FeedToken = hex.EncodeToString(b)
+	if err := bot.DB.SavePreferences(preferences); err != nil {
+		return "", err
+	}
+	return preferences.FeedToken, nil
+}
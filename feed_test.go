@@ -0,0 +1,105 @@
+package class_notify
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+func newTestFeeds(t *testing.T, events ...Event) (*testBot, *httptest.Server) {
+	t.Helper()
+	tb := newTestBot(t, events...)
+	server := httptest.NewServer((&Feeds{Bot: tb.discord.Bot, MaxAge: time.Minute}).Handler())
+	t.Cleanup(server.Close)
+	return tb, server
+}
+
+func getFeed(t *testing.T, server *httptest.Server, u string, etag string) (*http.Response, atomFeed) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var feed atomFeed
+	if resp.StatusCode == http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		if err := xml.Unmarshal(b, &feed); err != nil {
+			t.Fatalf("feed is not valid atom: %s\n%s", err, b)
+		}
+	}
+	return resp, feed
+}
+
+func TestClassFeed(t *testing.T) {
+	tb, server := newTestFeeds(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: fullDetails})
+	now := time.Now()
+	tb.store.AddHistory(HistoryEntry{URI: testClass, Name: "CS 1332", Status: schools.OPENED, Previous: schools.FULL, At: now})
+	tb.store.AddHistory(HistoryEntry{URI: otherClass, Name: "CS 2110", Status: schools.OPENED, At: now})
+	tb.store.AddHistory(HistoryEntry{URI: testClass, Name: "CS 1332", Status: schools.OPENED, At: now.Add(time.Minute),
+		Brief: &Brief{Status: schools.OPENED, Since: now.Add(time.Minute), Duration: 90 * time.Second}})
+
+	feedURL := server.URL + "/feeds/classes?class=" + url.QueryEscape(testClass)
+	resp, feed := getFeed(t, server, feedURL, "")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("feed = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "CS 1332 was briefly OPENED" || !strings.HasPrefix(feed.Entries[1].Summary, "Was FULL") {
+		t.Errorf("entries = %+v, want the changes of the class newest first", feed.Entries)
+	}
+
+	if resp, _ := getFeed(t, server, feedURL, resp.Header.Get("ETag")); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("unchanged feed = %d, want 304", resp.StatusCode)
+	}
+	if resp, _ := getFeed(t, server, server.URL+"/feeds/classes?class="+url.QueryEscape(otherClass), ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("untracked class feed = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestUserFeed(t *testing.T) {
+	tb, server := newTestFeeds(t,
+		Event{URI: testClass, School: "TEST", Subscribers: []string{"user"}, ClassDetails: openDetails},
+		Event{URI: otherClass, School: "TEST", Subscribers: []string{"other"}, ClassDetails: fullDetails})
+	tb.store.AddHistory(HistoryEntry{URI: testClass, Name: "CS 1332", Status: schools.OPENED, At: time.Now()})
+	tb.store.AddHistory(HistoryEntry{URI: otherClass, Name: "CS 2110", Status: schools.FULL, At: time.Now()})
+	tb.discord.PublicURL = server.URL
+
+	tb.discord.handleInteraction(command("classes", inDM("user")))
+	reply := tb.session.reply()
+	if !strings.Contains(reply, "/feeds/classes?class=") {
+		t.Errorf("classes = %q, want it to link the feed of the class", reply)
+	}
+	userFeed := regexp.MustCompile(`<(http[^>]*/feeds/users/[0-9a-f]{32})>`).FindStringSubmatch(reply)
+	if userFeed == nil {
+		t.Fatalf("classes = %q, want it to link the feed of the user", reply)
+	}
+
+	resp, feed := getFeed(t, server, userFeed[1], "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "private, max-age=60" {
+		t.Fatalf("user feed = %d %s", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "CS 1332 is OPENED" {
+		t.Errorf("entries = %+v, want only the changes of the user's classes", feed.Entries)
+	}
+	if token, _ := tb.discord.Bot.FeedToken("user"); !strings.HasSuffix(userFeed[1], token) {
+		t.Error("feed token changed once created")
+	}
+	if resp, _ := getFeed(t, server, server.URL+"/feeds/users/guessed", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown token = %d, want 404", resp.StatusCode)
+	}
+}
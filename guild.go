@@ -0,0 +1,61 @@
+package class_notify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GuildSettings holds the configuration of a discord server, set by its admins
+// with /config
+type GuildSettings struct {
+	GuildID string `bson:"guild_id"`
+	// DefaultSchool handles course identifiers given without a school
+	DefaultSchool string `bson:"default_school"`
+	// AllowedChannels restricts where commands may be used, any channel when empty
+	AllowedChannels []string `bson:"allowed_channels"`
+	// NotificationChannel is where class alerts for the server are posted
+	NotificationChannel string `bson:"notification_channel"`
+	// AdminRole may use /config in addition to members with Manage Server
+	AdminRole string `bson:"admin_role"`
+	Locale    string `bson:"locale"`
+}
+
+// ChannelAllowed reports whether commands may be used in channelID
+func (gs GuildSettings) ChannelAllowed(channelID string) bool {
+	return len(gs.AllowedChannels) == 0 || contains(gs.AllowedChannels, channelID)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (gs GuildSettings) String() string {
+	unset := func(s string) string {
+		if s == "" {
+			return "not set"
+		}
+		return s
+	}
+	channels := "any channel"
+	if len(gs.AllowedChannels) > 0 {
+		mentions := make([]string, len(gs.AllowedChannels))
+		for i, c := range gs.AllowedChannels {
+			mentions[i] = fmt.Sprintf("<#%s>", c)
+		}
+		channels = strings.Join(mentions, ", ")
+	}
+	notification, role := "not set", "not set"
+	if gs.NotificationChannel != "" {
+		notification = fmt.Sprintf("<#%s>", gs.NotificationChannel)
+	}
+	if gs.AdminRole != "" {
+		role = fmt.Sprintf("<@&%s>", gs.AdminRole)
+	}
+	return fmt.Sprintf("Default school: %s\nAllowed channels: %s\nNotification channel: %s\nAdmin role: %s\nLocale: %s",
+		unset(gs.DefaultSchool), channels, notification, role, unset(gs.Locale))
+}
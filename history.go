@@ -0,0 +1,93 @@
+package class_notify
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// HistoryEntry is a status a class settled on, or briefly had, kept to show how
+// the class changed over time
+type HistoryEntry struct {
+	// ID orders entries by when they were recorded, it is set by the store
+	ID                string              `bson:"-" json:"id"`
+	URI               string              `bson:"uri" json:"uri"`
+	School            string              `bson:"school" json:"school"`
+	Name              string              `bson:"name" json:"name"`
+	Status            schools.ClassStatus `bson:"status" json:"status"`
+	Previous          schools.ClassStatus `bson:"previous" json:"previous,omitempty"`
+	SeatsTotal        int                 `bson:"seats_total" json:"seats_total"`
+	SeatsRemaining    int                 `bson:"seats_remaining" json:"seats_remaining"`
+	WaitlistTotal     int                 `bson:"waitlist_total" json:"waitlist_total"`
+	WaitlistRemaining int                 `bson:"waitlist_remaining" json:"waitlist_remaining"`
+	At                time.Time           `bson:"at" json:"at"`
+	// Brief is set when Status did not last long enough to be alerted, the seats
+	// are then those seen once the class went back
+	Brief *Brief `bson:"brief,omitempty" json:"brief,omitempty"`
+}
+
+// HistoryFilter selects history entries, the zero value selects all of them
+type HistoryFilter struct {
+	URI string
+	// URIs selects the entries of any of these classes when URI is empty
+	URIs []string
+	// Schools selects the entries of any of these schools
+	Schools []string
+	// Courses selects the entries of classes whose name starts with one of them,
+	// as StreamFilter does
+	Courses []string
+	// AfterID selects the entries recorded after the one of this id
+	AfterID string
+	// Limit keeps the latest entries, or the first ones after AfterID
+	Limit int
+}
+
+// newHistoryEntry describes change as it is kept in the history
+func newHistoryEntry(change Change) HistoryEntry {
+	details := change.Event.ClassDetails
+	entry := HistoryEntry{
+		URI:               change.Event.URI,
+		School:            change.Event.School,
+		Name:              details.Name,
+		Status:            details.Status,
+		Previous:          change.Previous.Status,
+		SeatsTotal:        details.SeatsTotal,
+		SeatsRemaining:    details.SeatsRemaining,
+		WaitlistTotal:     details.WaitlistTotal,
+		WaitlistRemaining: details.WaitlistRemaining,
+		At:                change.At,
+	}
+	if change.Brief != nil {
+		entry.Status = change.Brief.Status
+		entry.Brief = change.Brief
+		entry.At = change.Brief.Since
+	}
+	return entry
+}
+
+// recordHistory adds change to the history, failing to do so does not stop its
+// alerts
+func (bot *Bot) recordHistory(change Change) {
+	entry, err := bot.DB.AddHistory(newHistoryEntry(change))
+	if err != nil {
+		slog.Warn("recording history failed", "check", change.CheckID, "event", change.Event.URI, "err", err)
+		return
+	}
+	bot.Stream.Publish(entry)
+}
+
+// History returns the latest limit entries of the history of the class of target,
+// oldest first
+func (bot *Bot) History(target string, defaultSchool string, limit int) ([]HistoryEntry, error) {
+	_, uri, err := bot.Schools.Resolve(target, defaultSchool)
+	if err != nil {
+		return nil, fmt.Errorf("resolving school of %s: %w", target, err)
+	}
+	entries, err := bot.DB.GetHistory(HistoryFilter{URI: uri, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("getting history of %s: %s", uri, err)
+	}
+	return entries, nil
+}
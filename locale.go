@@ -0,0 +1,115 @@
+package class_notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// messages are the texts of alerts and class details in the language of a locale
+type messages struct {
+	// changed titles an alert of a new status, briefly one of a brief status
+	changed string
+	briefly string
+	// was tells the status before an alert
+	was string
+	// briefFor describes a brief status: class, brief status, duration, status now
+	briefFor string
+	// remaining describes seats: remaining, total
+	remaining string
+
+	status, seats, waitlist, school, subscribers string
+
+	statuses map[schools.ClassStatus]string
+}
+
+var english = messages{
+	changed:     "CLASS STATUS HAS CHANGED TO %s",
+	briefly:     "CLASS WAS BRIEFLY %s",
+	was:         "was %s",
+	briefFor:    "%s\n%s for %s, it is %s again",
+	remaining:   "%d of %d remaining",
+	status:      "Status",
+	seats:       "Seats",
+	waitlist:    "Waitlist",
+	school:      "School",
+	subscribers: "Subscribers",
+}
+
+// localeMessages holds the languages alerts are written in, by discord locale
+var localeMessages = map[string]messages{
+	"fr": {
+		changed:     "LE STATUT DU COURS EST PASSÉ À %s",
+		briefly:     "LE COURS A ÉTÉ BRIÈVEMENT %s",
+		was:         "était %s",
+		briefFor:    "%s\n%s pendant %s, il est de nouveau %s",
+		remaining:   "%d sur %d restantes",
+		status:      "Statut",
+		seats:       "Places",
+		waitlist:    "Liste d'attente",
+		school:      "École",
+		subscribers: "Abonnés",
+		statuses: map[schools.ClassStatus]string{
+			schools.OPENED: "OUVERT", schools.WAITLISTED: "LISTE D'ATTENTE", schools.FULL: "COMPLET", schools.COMPLETED: "TERMINÉ",
+		},
+	},
+	"de": {
+		changed:     "KURSSTATUS IST JETZT %s",
+		briefly:     "KURS WAR KURZ %s",
+		was:         "war %s",
+		briefFor:    "%s\n%s für %s, jetzt wieder %s",
+		remaining:   "%d von %d frei",
+		status:      "Status",
+		seats:       "Plätze",
+		waitlist:    "Warteliste",
+		school:      "Hochschule",
+		subscribers: "Abonnenten",
+		statuses: map[schools.ClassStatus]string{
+			schools.OPENED: "OFFEN", schools.WAITLISTED: "WARTELISTE", schools.FULL: "VOLL", schools.COMPLETED: "BEENDET",
+		},
+	},
+	"es-ES": {
+		changed:     "EL ESTADO DE LA CLASE HA CAMBIADO A %s",
+		briefly:     "LA CLASE ESTUVO BREVEMENTE %s",
+		was:         "estaba %s",
+		briefFor:    "%s\n%s durante %s, vuelve a estar %s",
+		remaining:   "%d de %d libres",
+		status:      "Estado",
+		seats:       "Plazas",
+		waitlist:    "Lista de espera",
+		school:      "Universidad",
+		subscribers: "Suscriptores",
+		statuses: map[schools.ClassStatus]string{
+			schools.OPENED: "ABIERTA", schools.WAITLISTED: "EN LISTA DE ESPERA", schools.FULL: "LLENA", schools.COMPLETED: "TERMINADA",
+		},
+	},
+}
+
+// messagesFor returns the messages of locale, english for locales without them
+func messagesFor(locale string) messages {
+	if m, ok := localeMessages[locale]; ok {
+		return m
+	}
+	return english
+}
+
+// statusName returns status as it is written in the language of m
+func (m messages) statusName(status schools.ClassStatus) string {
+	if name, ok := m.statuses[status]; ok {
+		return name
+	}
+	return string(status)
+}
+
+func (m messages) changedTo(status schools.ClassStatus) string {
+	return fmt.Sprintf(m.changed, m.statusName(status))
+}
+
+func (m messages) wasStatus(status schools.ClassStatus) string {
+	return fmt.Sprintf(m.was, m.statusName(status))
+}
+
+func (m messages) brief(name string, brief Brief, status schools.ClassStatus) string {
+	return fmt.Sprintf(m.briefFor, name, m.statusName(brief.Status), brief.Duration.Round(time.Second), m.statusName(status))
+}
@@ -0,0 +1,59 @@
+package class_notify
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+// LogOptions configure the logger created by NewLogger
+type LogOptions struct {
+	Level slog.Level
+	// JSON writes one json object per line instead of key=value text
+	JSON bool
+	// RedactUsers replaces user ids by a keyed hash, the same user keeps the same
+	// hash until the process restarts so one user can still be followed in the logs
+	RedactUsers bool
+}
+
+// userKey is the attribute key of user ids, which are redacted when asked to
+const userKey = "user"
+
+// NewLogger creates a logger writing to w
+func NewLogger(w io.Writer, opts LogOptions) *slog.Logger {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	if opts.RedactUsers {
+		key := make([]byte, 32)
+		rand.Read(key)
+		handlerOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == userKey && a.Value.Kind() == slog.KindString {
+				return slog.String(userKey, redactUser(key, a.Value.String()))
+			}
+			return a
+		}
+	}
+	if opts.JSON {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts))
+}
+
+func redactUser(key []byte, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID))
+	return "u-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// newCorrelationID returns a random id tying together the logs of one check, which
+// is carried by the changes it finds down to the alerts sent
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
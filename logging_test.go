@@ -0,0 +1,126 @@
+package class_notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(NewLogger(&buf, LogOptions{Level: slog.LevelInfo, JSON: true, RedactUsers: true}))
+
+	tb := newTestBot(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"123456789"}, ClassDetails: fullDetails})
+	if err := tb.discord.Bot.Monitor("TEST", tb.discord.UpdateSubscriber); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "123456789") {
+		t.Errorf("logs contain the user id:\n%s", buf.String())
+	}
+	records := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %q is not json: %s", line, err)
+		}
+		records[record["msg"].(string)] = record
+	}
+	changed, sent := records["status changed"], records["alert sent"]
+	if changed == nil || sent == nil {
+		t.Fatalf("logs = %s, want the change and its alert", buf.String())
+	}
+	if changed["check"] == nil || changed["check"] != sent["check"] {
+		t.Errorf("check of change = %v, of alert = %v, want the same id", changed["check"], sent["check"])
+	}
+	if user, _ := sent[userKey].(string); !strings.HasPrefix(user, "u-") {
+		t.Errorf("user = %q, want it redacted", user)
+	}
+}
+
+func TestLoggingErrors(t *testing.T) {
+	const userID = "123456789"
+	dbErr := errors.New("connection refused")
+	tests := []struct {
+		name string
+		do   func(bot *Bot) error
+	}{
+		{"subscribing to a new class", func(bot *Bot) error {
+			_, err := bot.Subscribe(otherClass, "TEST", userID)
+			return err
+		}},
+		{"subscribing to a watched class", func(bot *Bot) error {
+			_, err := bot.Subscribe(testClass, "TEST", userID)
+			return err
+		}},
+		{"saving preferences", func(bot *Bot) error {
+			_, err := bot.FeedToken(userID)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(&buf, LogOptions{Level: slog.LevelInfo, JSON: true, RedactUsers: true})
+			tb := newTestBot(t, Event{URI: testClass, School: "TEST", ClassDetails: fullDetails})
+			tb.store.writeErr = dbErr
+
+			err := tt.do(tb.discord.Bot)
+			if !errors.Is(err, dbErr) {
+				t.Fatalf("error = %v, want the store error wrapped", err)
+			}
+			logger.Warn("failed", userKey, userID, "err", err)
+			if strings.Contains(buf.String(), userID) {
+				t.Errorf("logs contain the user id:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestLoggingDatabaseErrors(t *testing.T) {
+	const userID = "123456789"
+	// no database listens there, every query fails once no server was selected
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	db := &Database{
+		client:      client,
+		collection:  client.Database("test").Collection("classes"),
+		preferences: client.Database("test").Collection("preferences"),
+		queue:       client.Database("test").Collection("queue"),
+	}
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LogOptions{Level: slog.LevelInfo, JSON: true, RedactUsers: true})
+	for name, err := range map[string]error{
+		"adding subscriber":   db.AddSubscriber(testClass, userID),
+		"removing subscriber": db.RemoveSubscriber(testClass, userID),
+		"saving preferences":  db.SavePreferences(Preferences{UserID: userID}),
+		"queueing alert":      db.QueueAlert(QueuedAlert{UserID: userID}),
+	} {
+		if err == nil {
+			t.Fatalf("%s succeeded without a database", name)
+		}
+		logger.Warn(name+" failed", userKey, userID, "err", err)
+	}
+	_, err = db.GetEventsWithSubscriber(userID)
+	logger.Warn("getting events failed", userKey, userID, "err", err)
+	_, err = db.GetPreferences(userID)
+	logger.Warn("getting preferences failed", userKey, userID, "err", err)
+
+	if strings.Contains(buf.String(), userID) {
+		t.Errorf("logs contain the user id:\n%s", buf.String())
+	}
+}
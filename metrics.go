@@ -0,0 +1,201 @@
+package class_notify
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// Metrics counts what the bot does in the prometheus format. A nil *Metrics
+// records nothing.
+type Metrics struct {
+	checks        *prometheus.CounterVec
+	scrapeLatency *prometheus.HistogramVec
+	parseFailures *prometheus.CounterVec
+	transitions   *prometheus.CounterVec
+	notifications *prometheus.CounterVec
+	interactions  *prometheus.CounterVec
+	cycleDuration *prometheus.HistogramVec
+}
+
+// Notification backends and results counted by Metrics
+const (
+	backendDM      = "dm"
+	backendChannel = "channel"
+
+	resultSent   = "sent"
+	resultFailed = "failed"
+	resultQueued = "queued"
+)
+
+// NewMetrics creates the metrics of bot and registers them with reg, including
+// gauges of the active events, subscribers and queued alerts read from its store
+func NewMetrics(reg prometheus.Registerer, bot *Bot) (*Metrics, error) {
+	m := &Metrics{
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "class_notify_checks_total",
+			Help: "Class checks by school and result.",
+		}, []string{"school", "result"}),
+		scrapeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "class_notify_scrape_duration_seconds",
+			Help:    "Time taken to get the details of a class or a batch of classes.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"school"}),
+		parseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "class_notify_parse_failures_total",
+			Help: "Registrar pages that could not be parsed, by school.",
+		}, []string{"school"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "class_notify_status_transitions_total",
+			Help: "Settled status changes alerted to subscribers.",
+		}, []string{"school", "from", "to"}),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "class_notify_notifications_total",
+			Help: "Alerts by backend and result.",
+		}, []string{"backend", "result"}),
+		interactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "class_notify_interactions_total",
+			Help: "Discord interactions handled, by command or component.",
+		}, []string{"name"}),
+		cycleDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "class_notify_cycle_duration_seconds",
+			Help:    "Time taken to check every event of a school.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+		}, []string{"school"}),
+	}
+	collectors := []prometheus.Collector{
+		m.checks, m.scrapeLatency, m.parseFailures, m.transitions,
+		m.notifications, m.interactions, m.cycleDuration, &storeCollector{bot: bot},
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "class_notify_stream_listeners",
+			Help: "Clients listening to the stream of status changes.",
+		}, func() float64 { return float64(bot.Stream.Listeners()) }),
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WatchCache exports the counters of a fetch cache
+func WatchCache(reg prometheus.Registerer, cache *schools.CachingFetcher) error {
+	counters := map[string]func(stats schools.CacheStats) int64{
+		"hit":         func(stats schools.CacheStats) int64 { return stats.Hits },
+		"revalidated": func(stats schools.CacheStats) int64 { return stats.Revalidated },
+		"miss":        func(stats schools.CacheStats) int64 { return stats.Misses },
+		"coalesced":   func(stats schools.CacheStats) int64 { return stats.Coalesced },
+	}
+	for result, count := range counters {
+		count := count
+		err := reg.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "class_notify_fetch_cache_requests_total",
+			Help:        "Registrar fetches by how the cache answered them.",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 { return float64(count(cache.Stats())) }))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check records a check of the classes of a school that took since started
+func (m *Metrics) check(school string, classes int, started time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.scrapeLatency.WithLabelValues(school).Observe(time.Since(started).Seconds())
+	result := "ok"
+	if err != nil {
+		result = "error"
+		var parseErr *schools.ParseError
+		if errors.As(err, &parseErr) {
+			m.parseFailures.WithLabelValues(school).Inc()
+		}
+	}
+	m.checks.WithLabelValues(school, result).Add(float64(classes))
+}
+
+func (m *Metrics) transition(school string, from schools.ClassStatus, to schools.ClassStatus) {
+	if m == nil {
+		return
+	}
+	m.transitions.WithLabelValues(school, string(from), string(to)).Inc()
+}
+
+func (m *Metrics) notification(backend string, err error) {
+	if m == nil {
+		return
+	}
+	result := resultSent
+	if err != nil {
+		result = resultFailed
+	}
+	m.notifications.WithLabelValues(backend, result).Inc()
+}
+
+func (m *Metrics) queued() {
+	if m == nil {
+		return
+	}
+	m.notifications.WithLabelValues(backendDM, resultQueued).Inc()
+}
+
+func (m *Metrics) interaction(name string) {
+	if m == nil {
+		return
+	}
+	m.interactions.WithLabelValues(name).Inc()
+}
+
+func (m *Metrics) cycle(school string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.cycleDuration.WithLabelValues(school).Observe(duration.Seconds())
+}
+
+// storeCollector reads gauges from the store of a bot when metrics are scraped
+type storeCollector struct {
+	bot *Bot
+}
+
+var (
+	activeEventsDesc = prometheus.NewDesc("class_notify_active_events",
+		"Events whose class is still in session, by school.", []string{"school"}, nil)
+	subscribersDesc = prometheus.NewDesc("class_notify_active_subscribers",
+		"Subscriptions to active events, by school.", []string{"school"}, nil)
+	queueDepthDesc = prometheus.NewDesc("class_notify_queued_alerts",
+		"Alerts held back by quiet hours, digests or rate limits.", nil, nil)
+)
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeEventsDesc
+	ch <- subscribersDesc
+	ch <- queueDepthDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, id := range c.bot.Schools.IDs() {
+		isDefault := id == c.bot.Schools.Default
+		if count, err := c.bot.DB.GetActiveEventsCount(id, isDefault); err == nil {
+			ch <- prometheus.MustNewConstMetric(activeEventsDesc, prometheus.GaugeValue, float64(count), id)
+		} else {
+			ch <- prometheus.NewInvalidMetric(activeEventsDesc, err)
+		}
+		if count, err := c.bot.DB.GetActiveSubscribersCount(id, isDefault); err == nil {
+			ch <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(count), id)
+		} else {
+			ch <- prometheus.NewInvalidMetric(subscribersDesc, err)
+		}
+	}
+	if count, err := c.bot.DB.GetQueuedAlertsCount(); err == nil {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count))
+	} else {
+		ch <- prometheus.NewInvalidMetric(queueDepthDesc, err)
+	}
+}
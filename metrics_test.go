@@ -0,0 +1,64 @@
+package class_notify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+func TestMetrics(t *testing.T) {
+	tb := newTestBot(t, Event{URI: testClass, School: "TEST", Subscribers: []string{"user", "gone"}, ClassDetails: fullDetails})
+	tb.session.unavailable["gone"] = true
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg, tb.discord.Bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.discord.Bot.Metrics = metrics
+	tb.school.errs[otherClass] = &schools.ParseError{URI: otherClass, Err: errors.New("no status")}
+	tb.store.QueueAlert(QueuedAlert{UserID: "user", DeliverAt: time.Now().Add(time.Hour)})
+
+	if err := tb.discord.Bot.Monitor("TEST", tb.discord.UpdateSubscriber); err != nil {
+		t.Fatal(err)
+	}
+	tb.store.CreateEvent(Event{URI: otherClass, School: "TEST"})
+	if _, _, err := tb.discord.Bot.Status(otherClass, "TEST"); err == nil {
+		t.Fatal("want the parse error of the class")
+	}
+	tb.discord.handleInteraction(command("classes", inDM("user")))
+
+	counters := []struct {
+		counter prometheus.Collector
+		want    float64
+	}{
+		{metrics.checks.WithLabelValues("TEST", "ok"), 1},
+		{metrics.checks.WithLabelValues("TEST", "error"), 1},
+		{metrics.parseFailures.WithLabelValues("TEST"), 1},
+		{metrics.transitions.WithLabelValues("TEST", string(schools.FULL), string(schools.OPENED)), 1},
+		{metrics.notifications.WithLabelValues(backendDM, resultSent), 1},
+		{metrics.notifications.WithLabelValues(backendDM, resultFailed), 1},
+		{metrics.interactions.WithLabelValues("classes"), 1},
+	}
+	for _, c := range counters {
+		if got := testutil.ToFloat64(c.counter); got != c.want {
+			t.Errorf("%s = %v, want %v", c.counter.(prometheus.Metric).Desc(), got, c.want)
+		}
+	}
+
+	gauges := `
+# HELP class_notify_active_subscribers Subscriptions to active events, by school.
+# TYPE class_notify_active_subscribers gauge
+class_notify_active_subscribers{school="TEST"} 2
+# HELP class_notify_queued_alerts Alerts held back by quiet hours, digests or rate limits.
+# TYPE class_notify_queued_alerts gauge
+class_notify_queued_alerts 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(gauges), "class_notify_active_subscribers", "class_notify_queued_alerts"); err != nil {
+		t.Error(err)
+	}
+}
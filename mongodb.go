@@ -6,13 +6,22 @@ import (
 	"fmt"
 	"github.com/zMrKrabz/class-notify/schools"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type Database struct {
-	collection *mongo.Collection
+	client      *mongo.Client
+	collection  *mongo.Collection
+	guilds      *mongo.Collection
+	preferences *mongo.Collection
+	queue       *mongo.Collection
+	history     *mongo.Collection
 }
 
 func (db *Database) Connect(uri string) error {
@@ -20,6 +29,7 @@ func (db *Database) Connect(uri string) error {
 	if err != nil {
 		return fmt.Errorf("could not connect to data base: %s", err)
 	}
+	db.client = client
 	db.collection = client.Database("main").Collection("classes")
 
 	if indexName, err := db.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
@@ -29,8 +39,54 @@ func (db *Database) Connect(uri string) error {
 		return fmt.Errorf("creating unique index for uri field with indexName %s: %s",
 			indexName, err)
 	}
-	log.Println("connected to database collection successfully")
+	db.guilds = client.Database("main").Collection("guilds")
+	if indexName, err := db.guilds.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "guild_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("creating unique index for guild_id field with indexName %s: %s",
+			indexName, err)
+	}
+	db.preferences = client.Database("main").Collection("preferences")
+	if indexName, err := db.preferences.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("creating unique index for user_id field with indexName %s: %s",
+			indexName, err)
+	}
+	if indexName, err := db.preferences.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "feed_token", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	}); err != nil {
+		return fmt.Errorf("creating unique index for feed_token field with indexName %s: %s",
+			indexName, err)
+	}
+	db.queue = client.Database("main").Collection("queue")
+	if indexName, err := db.queue.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "deliver_at", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("creating index for deliver_at field with indexName %s: %s",
+			indexName, err)
+	}
+	db.history = client.Database("main").Collection("history")
+	if indexName, err := db.history.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "uri", Value: 1}, {Key: "_id", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("creating index for uri and _id fields with indexName %s: %s",
+			indexName, err)
+	}
+	slog.Info("connected to database")
+
+	return nil
+}
 
+func (db *Database) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("pinging database: %s", err)
+	}
 	return nil
 }
 
@@ -51,31 +107,36 @@ func (db *Database) GetAllEvents(c chan Event) error {
 	return nil
 }
 
-func (db *Database) GetAllActiveEvents(c chan Event) error {
-	// active events are events that are actively monitored, which are those that are not completed
-	filter := bson.D{{
-		"status", bson.D{{"$ne", schools.COMPLETED}},
-	}}
+// activeFilter matches the events of school that are actively monitored, which are
+// those that are not completed. Events stored before they were tagged with a school
+// belong to the default school.
+func activeFilter(school string, isDefault bool) bson.D {
+	schoolFilter := bson.D{{Key: "school", Value: school}}
+	if isDefault {
+		schoolFilter = bson.D{{Key: "school", Value: bson.D{{Key: "$in", Value: bson.A{school, nil}}}}}
+	}
+	return bson.D{
+		{Key: "class_details.status", Value: bson.D{{Key: "$ne", Value: schools.COMPLETED}}},
+		{Key: "$and", Value: bson.A{schoolFilter}},
+	}
+}
+
+// GetAllActiveEvents returns the events of school that are actively monitored
+func (db *Database) GetAllActiveEvents(school string, isDefault bool) ([]Event, error) {
+	filter := activeFilter(school, isDefault)
 	cursor, err := db.collection.Find(context.TODO(), filter)
 	if err != nil {
-		return fmt.Errorf("getting collection cursor: %s", err)
+		return nil, fmt.Errorf("getting collection cursor: %s", err)
 	}
-	defer cursor.Close(context.TODO())
-
-	for cursor.Next(context.TODO()) {
-		var result Event
-		if err := cursor.Decode(&result); err != nil {
-			return fmt.Errorf("decdoing result: %s", err)
-		}
-		c <- result
+	var events []Event
+	if err := cursor.All(context.TODO(), &events); err != nil {
+		return nil, fmt.Errorf("decoding results as events: %s", err)
 	}
-	return nil
+	return events, nil
 }
 
-func (db *Database) GetActiveEventsCount() (int64, error) {
-	filter := bson.D{{
-		"status", bson.D{{"$ne", schools.COMPLETED}},
-	}}
+func (db *Database) GetActiveEventsCount(school string, isDefault bool) (int64, error) {
+	filter := activeFilter(school, isDefault)
 	count, err := db.collection.CountDocuments(context.TODO(), filter)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count documents: %s", err)
@@ -83,10 +144,40 @@ func (db *Database) GetActiveEventsCount() (int64, error) {
 	return count, nil
 }
 
+// GetActiveSubscribersCount counts the subscriptions to active events of school,
+// counting a user once for every event they are subscribed to
+func (db *Database) GetActiveSubscribersCount(school string, isDefault bool) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: activeFilter(school, isDefault)}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: bson.D{
+				{Key: "$size", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$subscribers", bson.A{}}}}},
+			}}}},
+		}}},
+	}
+	cursor, err := db.collection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count subscribers: %s", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	var results []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return 0, fmt.Errorf("decoding subscriber count: %s", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
+
 var ErrNoSuchEvent = errors.New("class_notify: no classes exist with such uri")
 
 func (db *Database) GetEventWithURI(uri string) (Event, error) {
-	filter := bson.D{{"uri", uri}}
+	filter := bson.D{{Key: "uri", Value: uri}}
 	result := db.collection.FindOne(context.TODO(), filter)
 	if result.Err() != nil {
 		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
@@ -103,10 +194,10 @@ func (db *Database) GetEventWithURI(uri string) (Event, error) {
 }
 
 func (db *Database) GetEventsWithSubscriber(userID string) ([]Event, error) {
-	filter := bson.D{{"subscribers", userID}}
+	filter := bson.D{{Key: "subscribers", Value: userID}}
 	cursor, err := db.collection.Find(context.TODO(), filter)
 	if err != nil {
-		return nil, fmt.Errorf("getting cursor with filter %s: %s", filter, err)
+		return nil, fmt.Errorf("getting cursor of subscribed events: %s", err)
 	}
 
 	var events []Event
@@ -116,44 +207,83 @@ func (db *Database) GetEventsWithSubscriber(userID string) ([]Event, error) {
 	return events, nil
 }
 
-func (db *Database) CreateEvent(uri string, details schools.ClassDetails, userID string) (Event, error) {
-	subscribers := make([]string, 1)
-	subscribers[0] = userID
-	event := Event{
-		URI:          uri,
-		ClassDetails: details,
-		Subscribers:  subscribers,
-	}
-
+func (db *Database) CreateEvent(event Event) (Event, error) {
 	result, err := db.collection.InsertOne(context.TODO(), event)
 	if err != nil {
-		return Event{}, fmt.Errorf("inserting event %s: %s", event, err)
+		return Event{}, fmt.Errorf("inserting event %s: %s", event.URI, err)
 	}
-	log.Printf("created event %s with ID: %s\n", event, result.InsertedID)
+	slog.Debug("inserted event", "event", event.URI, "id", result.InsertedID)
 	return event, nil
 }
 
+var ErrAlreadySubscribed = errors.New("class_notify: user is already subscribed to the class")
+
 func (db *Database) AddSubscriber(uri string, subscriberID string) error {
-	filter := bson.D{{"uri", uri}}
-	update := bson.D{{"uri", bson.D{{"$push", bson.D{{"subscribers", subscriberID}}}}}}
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "subscribers", Value: subscriberID}}}}
 	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
-		return fmt.Errorf("update data base with filter %s and update query %s: %s",
-			filter, update, err)
+		// the update holds the id of the user, which is kept out of errors
+		return fmt.Errorf("adding subscriber to %s: %w", uri, err)
 	}
 	if result.MatchedCount == 0 {
 		return errors.New("unable to match any events with uri: " + uri)
 	}
 	if result.ModifiedCount == 0 {
-		return errors.New("unable to update any events with uri: " + uri)
+		return ErrAlreadySubscribed
 	}
-	log.Printf("successfuly added %s to %s event", subscriberID, uri)
+	slog.Debug("added subscriber to event document", "event", uri, userKey, subscriberID)
 	return nil
 }
 
+var ErrNotSubscribed = errors.New("class_notify: user is not subscribed to the class")
+
 func (db *Database) RemoveSubscriber(uri string, subscriberID string) error {
-	filter := bson.D{{"uri", uri}}
-	update := bson.D{{"$pull", bson.D{{"subscribers", subscriberID}}}}
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "subscribers", Value: subscriberID}}}}
+	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("removing subscriber from %s: %w", uri, err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("failed to match any events with uri: " + uri)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotSubscribed
+	}
+	slog.Debug("removed subscriber from event document", "event", uri, userKey, subscriberID)
+	return nil
+
+}
+
+// AddChannel posts the alerts of the event of uri to target, replacing the role of
+// a target already posting to the same channel
+func (db *Database) AddChannel(uri string, target ChannelTarget) error {
+	if err := db.RemoveChannel(uri, target.GuildID, target.ChannelID); err != nil && !errors.Is(err, ErrNoSuchChannel) {
+		return err
+	}
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "channels", Value: target}}}}
+	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("update data base with filter %s and update query %s: %s",
+			filter, update, err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("unable to match any events with uri: " + uri)
+	}
+	slog.Debug("added channel to event document", "event", uri, "channel", target.ChannelID)
+	return nil
+}
+
+var ErrNoSuchChannel = errors.New("class_notify: channel is not subscribed to the class")
+
+func (db *Database) RemoveChannel(uri string, guildID string, channelID string) error {
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "channels", Value: bson.D{
+		{Key: "guild_id", Value: guildID},
+		{Key: "channel_id", Value: channelID},
+	}}}}}
 	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
 		return fmt.Errorf("update data base with filter %s and update query %s: %s",
@@ -163,15 +293,14 @@ func (db *Database) RemoveSubscriber(uri string, subscriberID string) error {
 		return errors.New("failed to match any events with uri: " + uri)
 	}
 	if result.ModifiedCount == 0 {
-		return errors.New("failed to update any events with uri: " + uri)
+		return ErrNoSuchChannel
 	}
-	log.Printf("successfuly removed %s from %s event\n", subscriberID, uri)
+	slog.Debug("removed channel from event document", "event", uri, "channel", channelID)
 	return nil
-
 }
 
 func (db *Database) RemoveEvent(uri string) error {
-	filter := bson.D{{"uri", uri}}
+	filter := bson.D{{Key: "uri", Value: uri}}
 	result, err := db.collection.DeleteOne(context.TODO(), filter)
 	if err != nil {
 		return fmt.Errorf("DeleteOne with filter %s: %s", filter, err)
@@ -179,13 +308,13 @@ func (db *Database) RemoveEvent(uri string) error {
 	if result.DeletedCount == 0 {
 		return errors.New("failed to delete event with uri " + uri)
 	}
-	log.Println("successfully removed event with uri " + uri)
+	slog.Debug("deleted event document", "event", uri)
 	return nil
 }
 
 func (db *Database) UpdateEventDetails(uri string, details schools.ClassDetails) error {
-	filter := bson.D{{"uri", uri}}
-	update := bson.D{{"$set", bson.D{{"class_details", details}}}}
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "class_details", Value: details}}}}
 	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
 		return fmt.Errorf("failed to update event using filter %s and update query %s: %s",
@@ -195,10 +324,274 @@ func (db *Database) UpdateEventDetails(uri string, details schools.ClassDetails)
 		return fmt.Errorf("matched 0 documents with filter %s and update %s",
 			filter, update)
 	}
-	if result.ModifiedCount == 0 {
-		return fmt.Errorf("modified 0 documents with filter %s and update %s",
+	// details are unchanged most of the time, which modifies nothing
+	slog.Debug("updated class details", "event", uri, "status", details.Status)
+	return nil
+}
+
+// UpdateEventNotification stores the details subscribers of the event of uri were
+// last alerted of, and its status waiting to settle if any
+func (db *Database) UpdateEventNotification(uri string, notified schools.ClassDetails, pending *Pending) error {
+	filter := bson.D{{Key: "uri", Value: uri}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "notified", Value: notified}}}}
+	if pending == nil {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "pending", Value: ""}}})
+	} else {
+		update[0].Value = append(update[0].Value.(bson.D), bson.E{Key: "pending", Value: pending})
+	}
+	result, err := db.collection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update event using filter %s and update query %s: %s",
+			filter, update, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("matched 0 documents with filter %s and update %s",
 			filter, update)
 	}
-	log.Printf("successfully updated event %s with class details %s\n", uri, details)
 	return nil
 }
+
+// GetGuildSettings returns the settings of guildID, which are empty when the guild
+// was never configured
+func (db *Database) GetGuildSettings(guildID string) (GuildSettings, error) {
+	filter := bson.D{{Key: "guild_id", Value: guildID}}
+	result := db.guilds.FindOne(context.TODO(), filter)
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return GuildSettings{GuildID: guildID}, nil
+		}
+		return GuildSettings{}, fmt.Errorf("finding settings of guild %s: %s", guildID, result.Err())
+	}
+
+	var settings GuildSettings
+	if err := result.Decode(&settings); err != nil {
+		return GuildSettings{}, fmt.Errorf("decoding result %s", err)
+	}
+	return settings, nil
+}
+
+func (db *Database) SaveGuildSettings(settings GuildSettings) error {
+	filter := bson.D{{Key: "guild_id", Value: settings.GuildID}}
+	update := bson.D{{Key: "$set", Value: settings}}
+	if _, err := db.guilds.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("saving settings of guild %s: %s", settings.GuildID, err)
+	}
+	slog.Info("saved guild settings", "guild", settings.GuildID)
+	return nil
+}
+
+// GetPreferences returns the preferences of userID, which are empty when the user
+// never set any
+func (db *Database) GetPreferences(userID string) (Preferences, error) {
+	filter := bson.D{{Key: "user_id", Value: userID}}
+	result := db.preferences.FindOne(context.TODO(), filter)
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return Preferences{UserID: userID}, nil
+		}
+		return Preferences{}, fmt.Errorf("finding preferences: %s", result.Err())
+	}
+
+	var preferences Preferences
+	if err := result.Decode(&preferences); err != nil {
+		return Preferences{}, fmt.Errorf("decoding result %s", err)
+	}
+	return preferences, nil
+}
+
+var ErrNoSuchFeed = errors.New("class_notify: no feed exists with such token")
+
+func (db *Database) GetPreferencesWithFeedToken(token string) (Preferences, error) {
+	filter := bson.D{{Key: "feed_token", Value: token}}
+	result := db.preferences.FindOne(context.TODO(), filter)
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return Preferences{}, ErrNoSuchFeed
+		}
+		return Preferences{}, fmt.Errorf("finding preferences with feed token: %s", result.Err())
+	}
+
+	var preferences Preferences
+	if err := result.Decode(&preferences); err != nil {
+		return Preferences{}, fmt.Errorf("decoding result %s", err)
+	}
+	return preferences, nil
+}
+
+func (db *Database) SavePreferences(preferences Preferences) error {
+	filter := bson.D{{Key: "user_id", Value: preferences.UserID}}
+	update := bson.D{{Key: "$set", Value: preferences}}
+	if _, err := db.preferences.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("saving preferences: %s", err)
+	}
+	slog.Info("saved preferences", userKey, preferences.UserID)
+	return nil
+}
+
+func (db *Database) QueueAlert(alert QueuedAlert) error {
+	if _, err := db.queue.InsertOne(context.TODO(), alert); err != nil {
+		return fmt.Errorf("queueing alert of %s: %s", alert.Change.Event.URI, err)
+	}
+	return nil
+}
+
+// GetQueuedAlertsCount counts the alerts waiting to be delivered
+func (db *Database) GetQueuedAlertsCount() (int64, error) {
+	count, err := db.queue.CountDocuments(context.TODO(), bson.D{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count queued alerts: %s", err)
+	}
+	return count, nil
+}
+
+func (db *Database) ClaimDueAlerts(now time.Time, lease time.Duration) ([]QueuedAlert, error) {
+	// every alert updated by this claim carries its id, so that alerts claimed by
+	// another delivery at the same time are left out
+	claim := primitive.NewObjectID().Hex()
+	filter := bson.D{
+		{Key: "deliver_at", Value: bson.D{{Key: "$lte", Value: now}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "claimed_until", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "claimed_until", Value: bson.D{{Key: "$lte", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "claim", Value: claim},
+		{Key: "claimed_until", Value: now.Add(lease)},
+	}}}
+	if _, err := db.queue.UpdateMany(context.TODO(), filter, update); err != nil {
+		return nil, fmt.Errorf("claiming due alerts: %s", err)
+	}
+	cursor, err := db.queue.Find(context.TODO(), bson.D{{Key: "claim", Value: claim}})
+	if err != nil {
+		return nil, fmt.Errorf("getting cursor of claimed alerts: %s", err)
+	}
+	var stored []struct {
+		ID          primitive.ObjectID `bson:"_id"`
+		QueuedAlert `bson:",inline"`
+	}
+	if err := cursor.All(context.TODO(), &stored); err != nil {
+		return nil, fmt.Errorf("decoding results as queued alerts: %s", err)
+	}
+	if len(stored) == 0 {
+		return nil, nil
+	}
+
+	alerts := make([]QueuedAlert, len(stored))
+	for i, s := range stored {
+		alerts[i] = s.QueuedAlert
+		alerts[i].ID = s.ID.Hex()
+	}
+	return alerts, nil
+}
+
+func (db *Database) AckAlerts(ids []string) error {
+	filter, err := queuedAlertsFilter(ids)
+	if err != nil {
+		return err
+	}
+	if _, err := db.queue.DeleteMany(context.TODO(), filter); err != nil {
+		return fmt.Errorf("removing %d delivered alerts: %s", len(ids), err)
+	}
+	return nil
+}
+
+func (db *Database) ReleaseAlerts(ids []string) error {
+	filter, err := queuedAlertsFilter(ids)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "claim", Value: ""}, {Key: "claimed_until", Value: ""}}}}
+	if _, err := db.queue.UpdateMany(context.TODO(), filter, update); err != nil {
+		return fmt.Errorf("releasing %d alerts: %s", len(ids), err)
+	}
+	return nil
+}
+
+// queuedAlertsFilter selects the queued alerts of ids
+func queuedAlertsFilter(ids []string) (bson.D, error) {
+	in := make(bson.A, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("queued alert id %s is invalid: %s", id, err)
+		}
+		in[i] = objectID
+	}
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: in}}}}, nil
+}
+
+func (db *Database) AddHistory(entry HistoryEntry) (HistoryEntry, error) {
+	result, err := db.history.InsertOne(context.TODO(), entry)
+	if err != nil {
+		return HistoryEntry{}, fmt.Errorf("inserting history of %s: %s", entry.URI, err)
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return entry, nil
+}
+
+func (db *Database) GetHistory(filter HistoryFilter) ([]HistoryEntry, error) {
+	query := bson.D{}
+	if filter.URI != "" {
+		query = append(query, bson.E{Key: "uri", Value: filter.URI})
+	} else if len(filter.URIs) > 0 {
+		query = append(query, bson.E{Key: "uri", Value: bson.D{{Key: "$in", Value: filter.URIs}}})
+	}
+	if len(filter.Schools) > 0 {
+		query = append(query, bson.E{Key: "school", Value: bson.D{{Key: "$in", Value: filter.Schools}}})
+	}
+	if len(filter.Courses) > 0 {
+		courses := make(bson.A, len(filter.Courses))
+		for i, course := range filter.Courses {
+			courses[i] = primitive.Regex{Pattern: coursePattern(course), Options: "i"}
+		}
+		query = append(query, bson.E{Key: "name", Value: bson.D{{Key: "$in", Value: courses}}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if filter.AfterID != "" {
+		after, err := primitive.ObjectIDFromHex(filter.AfterID)
+		if err != nil {
+			return nil, fmt.Errorf("history id %s is invalid: %s", filter.AfterID, err)
+		}
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: after}}})
+	} else if filter.Limit > 0 {
+		// the latest entries are found newest first and put back in order below
+		opts.SetSort(bson.D{{Key: "_id", Value: -1}})
+	}
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := db.history.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("getting cursor with filter %s: %s", query, err)
+	}
+	var stored []struct {
+		ID           primitive.ObjectID `bson:"_id"`
+		HistoryEntry `bson:",inline"`
+	}
+	if err := cursor.All(context.TODO(), &stored); err != nil {
+		return nil, fmt.Errorf("decoding results as history entries: %s", err)
+	}
+	entries := make([]HistoryEntry, len(stored))
+	for i, s := range stored {
+		s.HistoryEntry.ID = s.ID.Hex()
+		entries[i] = s.HistoryEntry
+	}
+	if filter.AfterID == "" && filter.Limit > 0 {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries, nil
+}
+
+// coursePattern matches the names starting with course, ignoring spaces
+func coursePattern(course string) string {
+	var b strings.Builder
+	b.WriteString(`^`)
+	for _, r := range normalizeCourse(course) {
+		b.WriteString(`\s*`)
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return b.String()
+}
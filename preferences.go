@@ -0,0 +1,268 @@
+package class_notify
+
+import (
+	"fmt"
+	"github.com/zMrKrabz/class-notify/schools"
+	"strings"
+	"time"
+)
+
+// Preferences holds how a user wants to be alerted, set with /preferences
+type Preferences struct {
+	UserID string `bson:"user_id"`
+	// Timezone is an IANA zone name such as America/New_York, UTC when empty
+	Timezone string `bson:"timezone"`
+	// QuietStart and QuietEnd bound the hours alerts are held back, written as
+	// 15:04 in Timezone. Quiet hours are off when they are equal.
+	QuietStart string `bson:"quiet_start"`
+	QuietEnd   string `bson:"quiet_end"`
+	// Urgent transitions are alerted right away, even during quiet hours
+	Urgent []Transition `bson:"urgent"`
+	// Digest gathers alerts into one summary sent hourly or daily, alerts are sent
+	// one at a time when it is empty
+	Digest string `bson:"digest"`
+	// DigestTime is when daily digests are sent, written as 15:04 in Timezone
+	DigestTime string `bson:"digest_time"`
+	// Immediate holds the uris of classes alerted one at a time despite Digest
+	Immediate []string `bson:"immediate"`
+	// Rules narrow the alerts of some classes
+	Rules []Rule `bson:"rules"`
+	// FeedToken is the secret part of the url of the feed of the user's classes,
+	// created the first time the feed is linked
+	FeedToken string `bson:"feed_token,omitempty"`
+}
+
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+	// defaultDigestTime is when daily digests are sent when no time was set
+	defaultDigestTime = "09:00"
+)
+
+// Transition is a change of status, From matches any status when empty
+type Transition struct {
+	From schools.ClassStatus `bson:"from"`
+	To   schools.ClassStatus `bson:"to"`
+}
+
+// Rule narrows the alerts of the class of URI to the changes to one of Statuses
+type Rule struct {
+	URI      string                `bson:"uri"`
+	Statuses []schools.ClassStatus `bson:"statuses"`
+}
+
+func (t Transition) String() string {
+	from := string(t.From)
+	if from == "" {
+		from = "ANY"
+	}
+	return fmt.Sprintf("%s→%s", from, t.To)
+}
+
+// Location returns the timezone of the user
+func (p Preferences) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// parseClock parses a time of day written as 15:04 into minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a time of day such as 22:00", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// HasQuietHours reports whether the user set quiet hours
+func (p Preferences) HasQuietHours() bool {
+	start, err := parseClock(p.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(p.QuietEnd)
+	return err == nil && start != end
+}
+
+// InQuietHours reports whether t falls in the quiet hours of the user, which may
+// span midnight
+func (p Preferences) InQuietHours(t time.Time) bool {
+	if !p.HasQuietHours() {
+		return false
+	}
+	start, _ := parseClock(p.QuietStart)
+	end, _ := parseClock(p.QuietEnd)
+	local := t.In(p.Location())
+	now := local.Hour()*60 + local.Minute()
+	if start < end {
+		return start <= now && now < end
+	}
+	return now >= start || now < end
+}
+
+// QuietHoursEnd returns when the quiet hours t falls in end
+func (p Preferences) QuietHoursEnd(t time.Time) time.Time {
+	end, _ := parseClock(p.QuietEnd)
+	local := t.In(p.Location())
+	at := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+	if !at.After(local) {
+		at = time.Date(local.Year(), local.Month(), local.Day()+1, end/60, end%60, 0, 0, local.Location())
+	}
+	return at
+}
+
+// IsUrgent reports whether a change from one status to another should be alerted
+// during quiet hours
+func (p Preferences) IsUrgent(from schools.ClassStatus, to schools.ClassStatus) bool {
+	for _, t := range p.Urgent {
+		if t.To == to && (t.From == "" || t.From == from) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hold reports whether an alert of change detected at now should wait, and until when
+func (p Preferences) Hold(change Change, now time.Time) (bool, time.Time) {
+	if !p.InQuietHours(now) || p.IsUrgent(change.Previous.Status, change.Event.ClassDetails.Status) {
+		return false, time.Time{}
+	}
+	return true, p.QuietHoursEnd(now)
+}
+
+// Statuses returns the statuses the class of uri is alerted on, every status when
+// it is empty
+func (p Preferences) Statuses(uri string) []schools.ClassStatus {
+	for _, r := range p.Rules {
+		if r.URI == uri {
+			return r.Statuses
+		}
+	}
+	return nil
+}
+
+// SetRule alerts the class of uri only on changes to statuses, or on every change
+// when statuses is empty
+func (p *Preferences) SetRule(uri string, statuses []schools.ClassStatus) {
+	rules := make([]Rule, 0, len(p.Rules)+1)
+	for _, r := range p.Rules {
+		if r.URI != uri {
+			rules = append(rules, r)
+		}
+	}
+	if len(statuses) > 0 {
+		rules = append(rules, Rule{URI: uri, Statuses: statuses})
+	}
+	p.Rules = rules
+}
+
+// Wants reports whether the rules of the user let change be alerted
+func (p Preferences) Wants(change Change) bool {
+	statuses := p.Statuses(change.Event.URI)
+	if len(statuses) == 0 {
+		return true
+	}
+	status := change.Event.ClassDetails.Status
+	if change.Brief != nil {
+		status = change.Brief.Status
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// InDigest reports whether alerts of the class of uri go in the user's digest
+func (p Preferences) InDigest(uri string) bool {
+	return (p.Digest == DigestHourly || p.Digest == DigestDaily) && !contains(p.Immediate, uri)
+}
+
+// NextDigest returns when the digest gathering alerts at now is sent, which waits
+// for the end of quiet hours
+func (p Preferences) NextDigest(now time.Time) time.Time {
+	local := now.In(p.Location())
+	var at time.Time
+	if p.Digest == DigestHourly {
+		at = time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+1, 0, 0, 0, local.Location())
+	} else {
+		clock, err := parseClock(p.DigestTime)
+		if err != nil {
+			clock, _ = parseClock(defaultDigestTime)
+		}
+		at = time.Date(local.Year(), local.Month(), local.Day(), clock/60, clock%60, 0, 0, local.Location())
+		if !at.After(local) {
+			at = time.Date(local.Year(), local.Month(), local.Day()+1, clock/60, clock%60, 0, 0, local.Location())
+		}
+	}
+	if p.InQuietHours(at) {
+		return p.QuietHoursEnd(at)
+	}
+	return at
+}
+
+func (p Preferences) String() string {
+	var b strings.Builder
+	timezone := p.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	fmt.Fprintf(&b, "Timezone: %s\n", timezone)
+	if p.HasQuietHours() {
+		fmt.Fprintf(&b, "Quiet hours: %s to %s\n", p.QuietStart, p.QuietEnd)
+	} else {
+		b.WriteString("Quiet hours: off\n")
+	}
+	urgent := make([]string, 0, len(p.Urgent))
+	for _, t := range p.Urgent {
+		urgent = append(urgent, t.String())
+	}
+	if len(urgent) == 0 {
+		urgent = append(urgent, "none")
+	}
+	fmt.Fprintf(&b, "Urgent during quiet hours: %s\n", strings.Join(urgent, ", "))
+	switch p.Digest {
+	case DigestHourly:
+		b.WriteString("Digest: hourly")
+	case DigestDaily:
+		digestTime := p.DigestTime
+		if digestTime == "" {
+			digestTime = defaultDigestTime
+		}
+		fmt.Fprintf(&b, "Digest: daily at %s", digestTime)
+	default:
+		b.WriteString("Digest: off, every alert is sent right away")
+	}
+	if len(p.Immediate) > 0 && p.Digest != "" {
+		fmt.Fprintf(&b, "\nAlerted right away despite the digest: %d classes", len(p.Immediate))
+	}
+	if len(p.Rules) > 0 {
+		fmt.Fprintf(&b, "\nAlerted on some statuses only: %d classes", len(p.Rules))
+	}
+	return b.String()
+}
+
+// QueuedAlert is an alert held back by the quiet hours or the digest of its user
+type QueuedAlert struct {
+	// ID is set on alerts claimed for delivery
+	ID        string    `bson:"-"`
+	UserID    string    `bson:"user_id"`
+	Change    Change    `bson:"change"`
+	DeliverAt time.Time `bson:"deliver_at"`
+	// Digest alerts are sent together as one summary
+	Digest bool `bson:"digest"`
+	// Reason other alerts were held back, HeldForQuietHours or HeldForRateLimit
+	Reason string `bson:"reason"`
+}
+
+const (
+	HeldForQuietHours = "quiet_hours"
+	HeldForRateLimit  = "rate_limit"
+)
@@ -0,0 +1,135 @@
+package class_notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+func TestQuietHours(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	tests := []struct {
+		name        string
+		preferences Preferences
+		at          time.Time
+		quiet       bool
+		end         time.Time
+	}{
+		{
+			name:        "off",
+			preferences: Preferences{},
+			at:          time.Date(2022, 8, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "window spanning midnight, after midnight",
+			preferences: Preferences{QuietStart: "22:00", QuietEnd: "07:00"},
+			at:          time.Date(2022, 8, 1, 3, 0, 0, 0, time.UTC),
+			quiet:       true,
+			end:         time.Date(2022, 8, 1, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "window spanning midnight, before midnight",
+			preferences: Preferences{QuietStart: "22:00", QuietEnd: "07:00"},
+			at:          time.Date(2022, 8, 1, 23, 30, 0, 0, time.UTC),
+			quiet:       true,
+			end:         time.Date(2022, 8, 2, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "window spanning midnight, daytime",
+			preferences: Preferences{QuietStart: "22:00", QuietEnd: "07:00"},
+			at:          time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "window within a day ends exclusive",
+			preferences: Preferences{QuietStart: "13:00", QuietEnd: "14:00"},
+			at:          time.Date(2022, 8, 1, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "in the user's timezone",
+			preferences: Preferences{Timezone: "America/New_York", QuietStart: "22:00", QuietEnd: "07:00"},
+			// 23:00 in New York
+			at:    time.Date(2022, 8, 2, 3, 0, 0, 0, time.UTC),
+			quiet: true,
+			end:   time.Date(2022, 8, 2, 7, 0, 0, 0, newYork),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if quiet := tt.preferences.InQuietHours(tt.at); quiet != tt.quiet {
+				t.Fatalf("InQuietHours(%s) = %t, want %t", tt.at, quiet, tt.quiet)
+			}
+			if !tt.quiet {
+				return
+			}
+			if end := tt.preferences.QuietHoursEnd(tt.at); !end.Equal(tt.end) {
+				t.Errorf("QuietHoursEnd(%s) = %s, want %s", tt.at, end, tt.end)
+			}
+		})
+	}
+}
+
+func TestUrgentTransitions(t *testing.T) {
+	preferences := Preferences{
+		QuietStart: "00:00",
+		QuietEnd:   "23:59",
+		Urgent:     []Transition{{From: schools.FULL, To: schools.OPENED}, {To: schools.WAITLISTED}},
+	}
+	tests := []struct {
+		from, to schools.ClassStatus
+		urgent   bool
+	}{
+		{schools.FULL, schools.OPENED, true},
+		{schools.WAITLISTED, schools.OPENED, false},
+		{schools.FULL, schools.WAITLISTED, true},
+		{schools.OPENED, schools.WAITLISTED, true},
+		{schools.OPENED, schools.FULL, false},
+	}
+	at := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		change := Change{Previous: schools.ClassDetails{Status: tt.from}, Event: Event{ClassDetails: schools.ClassDetails{Status: tt.to}}}
+		if hold, _ := preferences.Hold(change, at); hold == tt.urgent {
+			t.Errorf("Hold(%s→%s) = %t, want %t", tt.from, tt.to, hold, !tt.urgent)
+		}
+	}
+}
+
+func TestNextDigest(t *testing.T) {
+	at := time.Date(2022, 8, 1, 10, 20, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		preferences Preferences
+		want        time.Time
+	}{
+		{
+			name:        "hourly",
+			preferences: Preferences{Digest: DigestHourly},
+			want:        time.Date(2022, 8, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "daily before its time",
+			preferences: Preferences{Digest: DigestDaily, DigestTime: "18:00"},
+			want:        time.Date(2022, 8, 1, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "daily after its time defaults to tomorrow morning",
+			preferences: Preferences{Digest: DigestDaily},
+			want:        time.Date(2022, 8, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "hourly waits for quiet hours to end",
+			preferences: Preferences{Digest: DigestHourly, QuietStart: "11:00", QuietEnd: "13:30"},
+			want:        time.Date(2022, 8, 1, 13, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.preferences.NextDigest(at); !got.Equal(tt.want) {
+				t.Errorf("NextDigest(%s) = %s, want %s", at, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package class_notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// processStarted stands for the last success of what never succeeded yet
+var processStarted = time.Now()
+
+// Probes answer the liveness and readiness probes of container orchestrators
+type Probes struct {
+	Bot     *Bot
+	Discord *Discord
+	// StuckAfter is how long past its poll interval a school may go without
+	// completing a check, and how long the discord gateway may stay
+	// disconnected, before the bot is reported as stuck
+	StuckAfter time.Duration
+}
+
+// ProbeReport describes every part of the bot, OK is false when the probe fails
+type ProbeReport struct {
+	OK bool `json:"ok"`
+	// Database is only checked by the readiness probe
+	Database *ComponentReport `json:"database,omitempty"`
+	Discord  ComponentReport  `json:"discord"`
+	Paused   bool             `json:"paused"`
+	Monitor  []CycleReport    `json:"monitor"`
+	Scrapers []ScraperReport  `json:"scrapers"`
+}
+
+type ComponentReport struct {
+	OK    bool       `json:"ok"`
+	Since *time.Time `json:"since,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// CycleReport tells how long ago a school last completed a check or a cycle
+type CycleReport struct {
+	School       string     `json:"school"`
+	OK           bool       `json:"ok"`
+	LastProgress *time.Time `json:"last_progress,omitempty"`
+	Age          string     `json:"age"`
+}
+
+// ScraperReport summarises the health of the scraper of a school. A failing
+// scraper does not fail the probes, restarting the bot would not fix it.
+type ScraperReport struct {
+	School              string  `json:"school"`
+	Failing             bool    `json:"failing"`
+	Checks              int     `json:"checks"`
+	FailureRate         float64 `json:"failure_rate"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	LastError           string  `json:"last_error,omitempty"`
+}
+
+// Live reports whether the bot is making progress: every school completed a check
+// recently, unless monitoring is paused, and the discord gateway did not stay
+// disconnected. Progress is tracked by check rather than by cycle so that a long
+// cycle is not mistaken for a stuck one, and failed checks count since a school
+// being down is reported by its scraper rather than fixed by a restart.
+func (p *Probes) Live(now time.Time) ProbeReport {
+	report := ProbeReport{OK: true, Paused: p.Bot.Paused()}
+	for _, id := range p.Bot.Schools.IDs() {
+		last := p.Bot.Progress(id)
+		since := last
+		if since.IsZero() {
+			since = processStarted
+		}
+		age := now.Sub(since)
+		cycle := CycleReport{
+			School:       id,
+			OK:           report.Paused || age <= p.Bot.Schools.PollInterval(id)+p.StuckAfter,
+			LastProgress: optionalTime(last),
+			Age:          age.Round(time.Second).String(),
+		}
+		report.OK = report.OK && cycle.OK
+		report.Monitor = append(report.Monitor, cycle)
+	}
+
+	connected, since := p.Discord.Connected()
+	report.Discord = ComponentReport{OK: connected, Since: optionalTime(since)}
+	if !connected {
+		report.Discord.Error = "gateway is disconnected"
+		if since.IsZero() {
+			since = processStarted
+		}
+		report.OK = report.OK && now.Sub(since) <= p.StuckAfter
+	}
+
+	if p.Bot.Health != nil {
+		for _, h := range p.Bot.Health.Snapshot() {
+			report.Scrapers = append(report.Scrapers, ScraperReport{
+				School:              h.School,
+				Failing:             h.Failing(p.Bot.Health.Threshold),
+				Checks:              h.Checks,
+				FailureRate:         h.FailureRate,
+				ConsecutiveFailures: h.ConsecutiveFailures,
+				LastError:           h.LastError,
+			})
+		}
+	}
+	return report
+}
+
+// Ready reports whether the bot can serve users: it is live, the database can be
+// reached and the discord gateway is connected
+func (p *Probes) Ready(now time.Time) ProbeReport {
+	report := p.Live(now)
+	report.Database = &ComponentReport{OK: true}
+	if err := p.Bot.DB.Ping(); err != nil {
+		report.Database = &ComponentReport{Error: err.Error()}
+		report.OK = false
+	}
+	report.OK = report.OK && report.Discord.OK
+	return report
+}
+
+// Healthz serves the liveness probe
+func (p *Probes) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, p.Live(time.Now()))
+}
+
+// Readyz serves the readiness probe
+func (p *Probes) Readyz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, p.Ready(time.Now()))
+}
+
+// optionalTime leaves zero times out of reports
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeProbe(w http.ResponseWriter, report ProbeReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
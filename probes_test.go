@@ -0,0 +1,101 @@
+package class_notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name string
+		// lastCheck is how long ago the last check completed
+		lastCheck time.Duration
+		connected bool
+		paused    bool
+		pingErr   error
+		healthz   int
+		readyz    int
+	}{
+		{name: "healthy", lastCheck: time.Minute, connected: true, healthz: http.StatusOK, readyz: http.StatusOK},
+		{name: "database unreachable", lastCheck: time.Minute, connected: true, pingErr: errors.New("no route to host"),
+			healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{name: "gateway reconnecting", lastCheck: time.Minute, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{name: "monitor stuck", lastCheck: time.Hour, connected: true,
+			healthz: http.StatusServiceUnavailable, readyz: http.StatusServiceUnavailable},
+		{name: "monitor paused", lastCheck: time.Hour, connected: true, paused: true, healthz: http.StatusOK, readyz: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t)
+			bot := tb.discord.Bot
+			bot.recordProgress("TEST", time.Now().Add(-tt.lastCheck))
+			if tt.paused {
+				bot.Pause()
+			}
+			tb.discord.setConnected(tt.connected)
+			tb.store.pingErr = tt.pingErr
+			probes := &Probes{Bot: bot, Discord: tb.discord, StuckAfter: 10 * time.Minute}
+
+			for path, want := range map[string]int{"/healthz": tt.healthz, "/readyz": tt.readyz} {
+				handler := probes.Healthz
+				if path == "/readyz" {
+					handler = probes.Readyz
+				}
+				rec := httptest.NewRecorder()
+				handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != want {
+					t.Errorf("%s = %d, want %d: %s", path, rec.Code, want, rec.Body)
+				}
+				var report ProbeReport
+				if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+					t.Fatalf("%s body is not a report: %s", path, err)
+				}
+				if len(report.Monitor) != 1 || report.Monitor[0].LastProgress == nil {
+					t.Errorf("%s monitor = %+v, want the last check of TEST", path, report.Monitor)
+				}
+			}
+		})
+	}
+}
+
+func TestProbesProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		// checked runs the checks of the cycle, which is otherwise stuck before them
+		checked  bool
+		checkErr error
+		live     bool
+	}{
+		{name: "long cycle with successful checks", checked: true, live: true},
+		{name: "long cycle with failing checks", checked: true, checkErr: errors.New("connection refused"), live: true},
+		{name: "stuck cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, Event{URI: testClass, School: "TEST", ClassDetails: fullDetails})
+			if tt.checkErr != nil {
+				tb.school.errs = map[string]error{testClass: tt.checkErr}
+			}
+			tb.discord.setConnected(true)
+			bot := tb.discord.Bot
+			probes := &Probes{Bot: bot, Discord: tb.discord, StuckAfter: 10 * time.Minute}
+
+			// the cycle started an hour ago, long past the poll interval, and is
+			// still running
+			bot.recordProgress("TEST", time.Now().Add(-time.Hour))
+			if tt.checked {
+				if err := bot.Monitor("TEST", func(change Change) error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if report := probes.Live(time.Now()); report.OK != tt.live {
+				t.Errorf("live = %t, want %t: %+v", report.OK, tt.live, report.Monitor)
+			}
+		})
+	}
+}
@@ -1,35 +1,89 @@
 package schools
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"io"
-	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
-type GeorgiaTech struct {}
+type GeorgiaTech struct {
+	// Fetcher downloads class pages, DefaultFetcher is used when nil
+	Fetcher Fetcher
+}
 
 func (gt *GeorgiaTech) GetClassDetails(uri string) (ClassDetails, error) {
 	if err := gt.validate(uri); err != nil {
-		return ClassDetails{}, fmt.Errorf("uri is invalid: %s", err)
+		return ClassDetails{}, fmt.Errorf("%w: uri is invalid: %s", ErrInvalidClass, err)
 	}
-	resp, err := http.Get(uri)
+	resp, err := fetcher(gt.Fetcher).Fetch(uri)
 	if err != nil {
-		return ClassDetails{}, fmt.Errorf("getting uri: %s", err)
+		return ClassDetails{}, fmt.Errorf("fetching uri: %w", err)
 	}
-	defer resp.Body.Close()
-	details, err := gt.parse(resp.Body)
+	details, err := gt.parse(bytes.NewReader(resp.Body))
 	if err != nil {
-		return ClassDetails{}, fmt.Errorf("parsing response body: %s", err)
+		return ClassDetails{}, &ParseError{URI: uri, Body: resp.Body, Err: err}
 	}
 	return details, nil
 }
 
+const georgiaTechClassURL = "https://oscar.gatech.edu/bprod/bwckschd.p_disp_detail_sched"
+
 func (gt *GeorgiaTech) validate(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Host != "oscar.gatech.edu" || !strings.HasSuffix(u.Path, "/bwckschd.p_disp_detail_sched") {
+		return fmt.Errorf("%s is not an oscar class page", uri)
+	}
+	query := u.Query()
+	if query.Get("term_in") == "" || query.Get("crn_in") == "" {
+		return fmt.Errorf("%s is missing term_in or crn_in", uri)
+	}
 	return nil
 }
 
+func (gt *GeorgiaTech) Matches(uri string) bool {
+	return gt.validate(uri) == nil
+}
+
+// CanonicalURI keeps only the term and crn of uri, in the order oscar links use
+func (gt *GeorgiaTech) CanonicalURI(uri string) (string, error) {
+	if err := gt.validate(uri); err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(uri)
+	query := u.Query()
+	return georgiaTechURL(query.Get("term_in"), query.Get("crn_in")), nil
+}
+
+func georgiaTechURL(term string, crn string) string {
+	return georgiaTechClassURL + "?term_in=" + url.QueryEscape(term) + "&crn_in=" + url.QueryEscape(crn)
+}
+
+func (gt *GeorgiaTech) ExampleURI() string {
+	uri, _ := gt.CourseURI("202208/80123")
+	return uri
+}
+
+// CourseURI accepts courses written as <term>/<crn>, such as 202208/80123
+func (gt *GeorgiaTech) CourseURI(course string) (string, error) {
+	parts := strings.Split(course, "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("%s should look like <term>/<crn>", course)
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return "", fmt.Errorf("%s is not a number", part)
+		}
+	}
+	return georgiaTechURL(parts[0], parts[1]), nil
+}
+
 func (gt *GeorgiaTech) parse(body io.Reader) (ClassDetails, error) {
 	doc, err := goquery.NewDocumentFromReader(body)
 	if err != nil {
@@ -59,14 +113,12 @@ func (gt *GeorgiaTech) parse(body io.Reader) (ClassDetails, error) {
 	}
 
 	var status ClassStatus
-	if seatsCap >= seatsActual {
-		if waitlistCap > waitlistActual {
-			status = WAITLISTED
-		} else {
-			status = FULL
-		}
-	} else {
+	if seatsCap > seatsActual {
 		status = OPENED
+	} else if waitlistCap > waitlistActual {
+		status = WAITLISTED
+	} else {
+		status = FULL
 	}
 
 	return ClassDetails{
@@ -76,6 +128,6 @@ func (gt *GeorgiaTech) parse(body io.Reader) (ClassDetails, error) {
 		SeatsTotal:        seatsCap,
 		SeatsRemaining:    seatsCap - seatsActual,
 		WaitlistTotal:     waitlistCap,
-		WaitlistRemaining: waitlistActual,
+		WaitlistRemaining: waitlistCap - waitlistActual,
 	}, nil
-}
\ No newline at end of file
+}
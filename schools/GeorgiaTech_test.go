@@ -0,0 +1,48 @@
+package schools
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// oscarPage is a detailed class information page of OSCAR with the given seats
+// and waitlist seats, capacity then actual
+func oscarPage(seatsCap, seatsActual, waitlistCap, waitlistActual int) string {
+	return fmt.Sprintf(`<html><body><div class="pagebodydiv"><a name="main_content"></a>
+<table class="datadisplaytable">
+<tr><th class="ddlabel">Data Structures &amp; Algorithms - 87695 - CS 1332 - B</th></tr>
+<tr><td class="dddefault"><table class="datadisplaytable">
+<tr><th></th><th>Capacity</th><th>Actual</th><th>Remaining</th></tr>
+<tr><th>Seats</th><td>%d</td><td>%d</td><td>0</td></tr>
+<tr><th>Waitlist Seats</th><td>%d</td><td>%d</td><td>0</td></tr>
+</table></td></tr>
+</table></div></body></html>`, seatsCap, seatsActual, waitlistCap, waitlistActual)
+}
+
+func TestGeorgiaTechParse(t *testing.T) {
+	tests := []struct {
+		name              string
+		page              string
+		status            ClassStatus
+		seatsRemaining    int
+		waitlistRemaining int
+	}{
+		{"open seats", oscarPage(300, 291, 50, 0), OPENED, 9, 50},
+		{"open waitlist", oscarPage(200, 200, 40, 28), WAITLISTED, 0, 12},
+		{"full", oscarPage(200, 200, 40, 40), FULL, 0, 0},
+		{"over capacity", oscarPage(200, 203, 0, 0), FULL, -3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := (&GeorgiaTech{}).parse(strings.NewReader(tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.Status != tt.status || details.SeatsRemaining != tt.seatsRemaining || details.WaitlistRemaining != tt.waitlistRemaining {
+				t.Errorf("details = %+v, want %s with %d seats and %d waitlist seats remaining",
+					details, tt.status, tt.seatsRemaining, tt.waitlistRemaining)
+			}
+		})
+	}
+}
@@ -6,6 +6,17 @@ type ISchool interface {
 	GetClassDetails(uri string) (ClassDetails, error)
 }
 
+// BatchSchool is implemented by schools that can check many classes in one request
+type BatchSchool interface {
+	ISchool
+	// BatchKey groups uris that can be fetched together, such as by term and subject.
+	// uris with an empty key are checked one at a time.
+	BatchKey(uri string) string
+	// GetManyClassDetails returns the details of the uris of a group that it found,
+	// missing uris are checked one at a time
+	GetManyClassDetails(uris []string) (map[string]ClassDetails, error)
+}
+
 type ClassDetails struct {
 	Name              string      `bson:"name"`
 	Description       string      `bson:"description"`
@@ -27,7 +38,7 @@ func (cd ClassDetails) String() string {
 type ClassStatus string
 
 const (
-	FULL     ClassStatus = "FULL"
+	FULL       ClassStatus = "FULL"
 	WAITLISTED             = "WAITLISTED"
 	OPENED                 = "OPENED"
 	COMPLETED              = "COMPLETED" // Term is over, class is no longer in session for given url
@@ -0,0 +1,137 @@
+package schools
+
+import (
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachingFetcher sits in front of an HTTPFetcher to cut down on requests to the
+// registrar. Responses are reused for TTL, concurrent fetches of the same uri share
+// one request, and stale responses are revalidated with conditional requests using
+// their ETag and Last-Modified headers.
+type CachingFetcher struct {
+	Fetcher *HTTPFetcher
+	// TTL is how long a response is served without asking the registrar again
+	TTL time.Duration
+	// MaxAge is how long a response is kept around for revalidation
+	MaxAge time.Duration
+
+	group   singleflight.Group
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+
+	hits        int64
+	revalidated int64
+	misses      int64
+	coalesced   int64
+}
+
+type cacheEntry struct {
+	resp    *Response
+	fetched time.Time
+}
+
+// CacheStats counts how requests to a CachingFetcher were answered
+type CacheStats struct {
+	// Hits were answered from memory without a request
+	Hits int64
+	// Revalidated were answered from memory after the registrar answered 304
+	Revalidated int64
+	// Misses needed the page to be downloaded
+	Misses int64
+	// Coalesced shared a request with a concurrent fetch of the same uri
+	Coalesced int64
+}
+
+// HitRate is the share of fetches that did not need the page to be downloaded
+func (cs CacheStats) HitRate() float64 {
+	total := cs.Hits + cs.Revalidated + cs.Misses + cs.Coalesced
+	if total == 0 {
+		return 0
+	}
+	return float64(total-cs.Misses) / float64(total)
+}
+
+func NewCachingFetcher(fetcher *HTTPFetcher, ttl time.Duration) *CachingFetcher {
+	return &CachingFetcher{
+		Fetcher: fetcher,
+		TTL:     ttl,
+		MaxAge:  time.Hour,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+func (c *CachingFetcher) Fetch(uri string) (*Response, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[uri]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetched) < c.TTL {
+		atomic.AddInt64(&c.hits, 1)
+		return entry.resp, nil
+	}
+
+	// the fetch that made the request is counted by revalidate, only those that
+	// waited for it are coalesced
+	leader := false
+	resp, err, shared := c.group.Do(uri, func() (interface{}, error) {
+		leader = true
+		return c.revalidate(uri, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared && !leader {
+		atomic.AddInt64(&c.coalesced, 1)
+	}
+	return resp.(*Response), nil
+}
+
+// revalidate fetches uri, asking only for changes since entry when there is one
+func (c *CachingFetcher) revalidate(uri string, entry *cacheEntry) (*Response, error) {
+	header := make(http.Header)
+	if entry != nil {
+		if etag := entry.resp.Header.Get("ETag"); etag != "" {
+			header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.resp.Header.Get("Last-Modified"); lastModified != "" {
+			header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := c.Fetcher.FetchWithHeader(uri, header)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		atomic.AddInt64(&c.revalidated, 1)
+		c.store(uri, &cacheEntry{resp: entry.resp, fetched: now})
+		return entry.resp, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+	c.store(uri, &cacheEntry{resp: resp, fetched: now})
+	return resp, nil
+}
+
+func (c *CachingFetcher) store(uri string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if time.Since(e.fetched) > c.MaxAge {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[uri] = entry
+}
+
+func (c *CachingFetcher) Stats() CacheStats {
+	return CacheStats{
+		Hits:        atomic.LoadInt64(&c.hits),
+		Revalidated: atomic.LoadInt64(&c.revalidated),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Coalesced:   atomic.LoadInt64(&c.coalesced),
+	}
+}
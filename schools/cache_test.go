@@ -0,0 +1,109 @@
+package schools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// registrar is a test server answering conditional requests for a page that
+// changes when version is bumped
+type registrar struct {
+	*httptest.Server
+	requests    atomic.Int32
+	conditional atomic.Int32
+	version     atomic.Int32
+	// release, when set, holds every request until it is closed
+	release chan struct{}
+}
+
+func newRegistrar(t *testing.T, release chan struct{}) *registrar {
+	r := &registrar{release: release}
+	lastModified := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests.Add(1)
+		if r.release != nil {
+			<-r.release
+		}
+		etag := `"v` + strconv.Itoa(int(r.version.Load())) + `"`
+		if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+			r.conditional.Add(1)
+			if req.Header.Get("If-None-Match") == etag && req.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte("page " + etag))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestCachingFetcher(t *testing.T) {
+	r := newRegistrar(t, nil)
+	c := NewCachingFetcher(&HTTPFetcher{Client: r.Client()}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if resp, err := c.Fetch(r.URL); err != nil || string(resp.Body) != `page "v0"` {
+			t.Fatalf("fetch = %v %v", resp, err)
+		}
+	}
+	if r.requests.Load() != 1 {
+		t.Errorf("requests = %d, want the page reused within its ttl", r.requests.Load())
+	}
+
+	// once stale, the page is revalidated with its etag and last modified date
+	c.TTL = 0
+	resp, err := c.Fetch(r.URL)
+	if err != nil || string(resp.Body) != `page "v0"` || r.conditional.Load() != 1 {
+		t.Errorf("fetch = %v %v after %d conditional requests, want the cached page revalidated", resp, err, r.conditional.Load())
+	}
+	r.version.Add(1)
+	if resp, err := c.Fetch(r.URL); err != nil || string(resp.Body) != `page "v1"` {
+		t.Errorf("fetch = %v %v, want the changed page", resp, err)
+	}
+
+	stats := c.Stats()
+	if stats != (CacheStats{Hits: 2, Revalidated: 1, Misses: 2}) || stats.HitRate() != 0.6 {
+		t.Errorf("stats = %+v with hit rate %f", stats, stats.HitRate())
+	}
+}
+
+func TestCachingFetcherCoalesces(t *testing.T) {
+	release := make(chan struct{})
+	r := newRegistrar(t, release)
+	c := NewCachingFetcher(&HTTPFetcher{Client: r.Client()}, time.Hour)
+
+	const fetches = 5
+	var wg sync.WaitGroup
+	for i := 0; i < fetches; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Fetch(r.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// wait for the first request to reach the registrar, and the others to wait on it
+	for r.requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if r.requests.Load() != 1 {
+		t.Errorf("requests = %d, want concurrent fetches to share one", r.requests.Load())
+	}
+	stats := c.Stats()
+	if stats.Misses != 1 || stats.Misses+stats.Coalesced+stats.Hits != fetches {
+		t.Errorf("stats = %+v, want %d fetches counted once each", stats, fetches)
+	}
+}
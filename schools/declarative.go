@@ -0,0 +1,805 @@
+package schools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Definition describes how to scrape a school's registrar without writing Go code.
+// Definitions are stored as JSON files and turned into a Scraper with Compile.
+type Definition struct {
+	// ID is the name used to select the school, e.g. on the -school flag
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Match is a regular expression every subscribed uri must match. Named groups
+	// are made available to FetchURL.
+	Match string `json:"match"`
+	// FetchURL is a text/template of the url to download, executed with the named
+	// groups of Match and the original uri as {{.uri}}. Defaults to the uri itself.
+	FetchURL string `json:"fetch_url"`
+	// Example is a class url matching Match, used to tell whether two schools claim
+	// the same urls
+	Example string `json:"example"`
+	// Format is either "html" (fields use CSS selectors) or "json" (fields use paths)
+	Format string `json:"format"`
+	// Fields maps ClassDetails fields to where their values are found in the page.
+	// Known fields are name, description, seats_total, seats_taken, seats_remaining,
+	// waitlist_total, waitlist_taken and waitlist_remaining.
+	Fields map[string]FieldRule `json:"fields"`
+	// Status rules are evaluated in order, the first one whose condition holds wins
+	Status        []StatusRule `json:"status"`
+	DefaultStatus ClassStatus  `json:"default_status"`
+	// Batch optionally describes a listing page holding many classes at once
+	Batch *BatchDefinition `json:"batch"`
+	// Course optionally lets users subscribe with a course identifier instead of a url
+	Course *CourseDefinition `json:"course"`
+}
+
+// CourseDefinition turns a course identifier into the url of its class
+type CourseDefinition struct {
+	// Pattern is a regular expression identifiers must match, such as "(?P<term>\d+)/(?P<crn>\d+)"
+	Pattern string `json:"pattern"`
+	// URL is a template of the class url, executed with the named groups of Pattern
+	URL string `json:"url"`
+}
+
+// BatchDefinition describes a listing, such as a class search by term and subject,
+// that returns the details of many classes in one request. Templates are executed
+// the same way as FetchURL.
+type BatchDefinition struct {
+	// Group is a template whose output is the same for uris listed on the same page
+	Group string `json:"group"`
+	// FetchURL is a template of the listing url, executed with the first uri of a group
+	FetchURL string `json:"fetch_url"`
+	// Rows selects one element per class (html) or is the path of the array of
+	// classes (json). Fields and KeyField are relative to a row.
+	Rows string `json:"rows"`
+	// Key is a template of the identifier of a uri, such as its CRN
+	Key string `json:"key"`
+	// KeyField is where the identifier of a row is found
+	KeyField FieldRule            `json:"key_field"`
+	Fields   map[string]FieldRule `json:"fields"`
+}
+
+type FieldRule struct {
+	// Selector is a CSS selector, used with the html format
+	Selector string `json:"selector"`
+	// Attr reads an attribute of the selected element instead of its text
+	Attr string `json:"attr"`
+	// Path is a dot separated path into the document, used with the json format
+	Path string `json:"path"`
+	// Regex optionally narrows the extracted text down to its first capture group
+	Regex string `json:"regex"`
+}
+
+// StatusRule sets Status when When holds. When is a list of comparisons joined by
+// "&&", where each side is a field name or an integer, e.g. "seats_remaining > 0".
+type StatusRule struct {
+	Status ClassStatus `json:"status"`
+	When   string      `json:"when"`
+}
+
+const (
+	fieldName              = "name"
+	fieldDescription       = "description"
+	fieldSeatsTotal        = "seats_total"
+	fieldSeatsTaken        = "seats_taken"
+	fieldSeatsRemaining    = "seats_remaining"
+	fieldWaitlistTotal     = "waitlist_total"
+	fieldWaitlistTaken     = "waitlist_taken"
+	fieldWaitlistRemaining = "waitlist_remaining"
+)
+
+var textFields = map[string]bool{
+	fieldName:        true,
+	fieldDescription: true,
+}
+
+var numberFields = map[string]bool{
+	fieldSeatsTotal:        true,
+	fieldSeatsTaken:        true,
+	fieldSeatsRemaining:    true,
+	fieldWaitlistTotal:     true,
+	fieldWaitlistTaken:     true,
+	fieldWaitlistRemaining: true,
+}
+
+// Scraper is an ISchool built from a Definition
+type Scraper struct {
+	Definition Definition
+	// Fetcher downloads class pages, DefaultFetcher is used when nil
+	Fetcher Fetcher
+
+	match     *regexp.Regexp
+	fetchURL  *template.Template
+	fields    map[string]compiledField
+	status    []compiledRule
+	batch     *compiledBatch
+	course    *regexp.Regexp
+	courseURL *template.Template
+}
+
+type compiledBatch struct {
+	group    *template.Template
+	fetchURL *template.Template
+	key      *template.Template
+	rows     cascadia.Selector
+	keyField compiledField
+	fields   map[string]compiledField
+}
+
+type compiledField struct {
+	FieldRule
+	selector cascadia.Selector
+	regex    *regexp.Regexp
+}
+
+type compiledRule struct {
+	status     ClassStatus
+	conditions []condition
+}
+
+type condition struct {
+	left, op, right string
+}
+
+// LoadDefinitions compiles every *.json definition found in dir
+func LoadDefinitions(dir string) ([]*Scraper, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing definitions in %s: %s", dir, err)
+	}
+	scrapers := make([]*Scraper, 0, len(paths))
+	seen := make(map[string]string)
+	for _, path := range paths {
+		scraper, err := LoadDefinition(path)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[scraper.Definition.ID]; ok {
+			return nil, fmt.Errorf("definition %s in %s is already defined in %s",
+				scraper.Definition.ID, path, other)
+		}
+		seen[scraper.Definition.ID] = path
+		scrapers = append(scrapers, scraper)
+	}
+	return scrapers, nil
+}
+
+// LoadDefinition compiles the definition stored at path
+func LoadDefinition(path string) (*Scraper, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading definition %s: %s", path, err)
+	}
+	var def Definition
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&def); err != nil {
+		return nil, fmt.Errorf("decoding definition %s: %s", path, err)
+	}
+	scraper, err := Compile(def)
+	if err != nil {
+		return nil, fmt.Errorf("compiling definition %s: %s", path, err)
+	}
+	return scraper, nil
+}
+
+// Compile validates def and prepares its selectors, templates and rules
+func Compile(def Definition) (*Scraper, error) {
+	if def.ID == "" {
+		return nil, errors.New("definition is missing an id")
+	}
+	if def.Format == "" {
+		def.Format = "html"
+	}
+	if def.Format != "html" && def.Format != "json" {
+		return nil, fmt.Errorf("unknown format %q, expected html or json", def.Format)
+	}
+	if def.DefaultStatus == "" {
+		def.DefaultStatus = FULL
+	}
+
+	s := &Scraper{
+		Definition: def,
+	}
+	if def.Match != "" {
+		match, err := regexp.Compile(def.Match)
+		if err != nil {
+			return nil, fmt.Errorf("compiling match %q: %s", def.Match, err)
+		}
+		s.match = match
+		if def.Example != "" && !match.MatchString(def.Example) {
+			return nil, fmt.Errorf("example %s does not match %s", def.Example, def.Match)
+		}
+	}
+	if def.FetchURL != "" {
+		tmpl, err := template.New(def.ID).Option("missingkey=error").Parse(def.FetchURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing fetch_url %q: %s", def.FetchURL, err)
+		}
+		s.fetchURL = tmpl
+	}
+
+	fields, err := compileFields(def.Format, def.Fields)
+	if err != nil {
+		return nil, err
+	}
+	s.fields = fields
+
+	for _, rule := range def.Status {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("compiling status rule %s: %s", rule.Status, err)
+		}
+		s.status = append(s.status, compiled)
+	}
+
+	if def.Course != nil {
+		course, err := regexp.Compile("^(?:" + def.Course.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("compiling course pattern %q: %s", def.Course.Pattern, err)
+		}
+		courseURL, err := template.New(def.ID + ".course").Option("missingkey=error").Parse(def.Course.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing course url %q: %s", def.Course.URL, err)
+		}
+		s.course, s.courseURL = course, courseURL
+	}
+
+	if def.Batch != nil {
+		batch, err := compileBatch(def.ID, def.Format, *def.Batch)
+		if err != nil {
+			return nil, fmt.Errorf("compiling batch: %s", err)
+		}
+		s.batch = batch
+	}
+	return s, nil
+}
+
+func compileBatch(id string, format string, def BatchDefinition) (*compiledBatch, error) {
+	batch := &compiledBatch{}
+	templates := []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"group", def.Group, &batch.group},
+		{"fetch_url", def.FetchURL, &batch.fetchURL},
+		{"key", def.Key, &batch.key},
+	}
+	for _, t := range templates {
+		if t.text == "" {
+			return nil, fmt.Errorf("missing %s", t.name)
+		}
+		tmpl, err := template.New(id + "." + t.name).Option("missingkey=error").Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s %q: %s", t.name, t.text, err)
+		}
+		*t.dst = tmpl
+	}
+
+	if def.Rows == "" {
+		return nil, errors.New("missing rows")
+	}
+	if format == "html" {
+		rows, err := cascadia.Compile(def.Rows)
+		if err != nil {
+			return nil, fmt.Errorf("compiling rows selector: %s", err)
+		}
+		batch.rows = rows
+	}
+	keyField, err := compileField(format, def.KeyField)
+	if err != nil {
+		return nil, fmt.Errorf("key_field: %s", err)
+	}
+	batch.keyField = keyField
+	fields, err := compileFields(format, def.Fields)
+	if err != nil {
+		return nil, err
+	}
+	batch.fields = fields
+	return batch, nil
+}
+
+func compileFields(format string, rules map[string]FieldRule) (map[string]compiledField, error) {
+	if _, ok := rules[fieldName]; !ok {
+		return nil, errors.New("fields is missing name")
+	}
+	fields := make(map[string]compiledField, len(rules))
+	for name, rule := range rules {
+		if !textFields[name] && !numberFields[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		field, err := compileField(format, rule)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", name, err)
+		}
+		fields[name] = field
+	}
+	has := func(name string) bool {
+		_, ok := fields[name]
+		return ok
+	}
+	if !has(fieldSeatsRemaining) && !(has(fieldSeatsTotal) && has(fieldSeatsTaken)) {
+		return nil, errors.New("fields needs seats_remaining, or seats_total and seats_taken")
+	}
+	return fields, nil
+}
+
+func compileField(format string, rule FieldRule) (compiledField, error) {
+	field := compiledField{FieldRule: rule}
+	switch format {
+	case "html":
+		if rule.Selector == "" {
+			return compiledField{}, errors.New("missing a selector")
+		}
+		selector, err := cascadia.Compile(rule.Selector)
+		if err != nil {
+			return compiledField{}, fmt.Errorf("compiling selector: %s", err)
+		}
+		field.selector = selector
+	case "json":
+		if rule.Path == "" {
+			return compiledField{}, errors.New("missing a path")
+		}
+	}
+	if rule.Regex != "" {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return compiledField{}, fmt.Errorf("compiling regex: %s", err)
+		}
+		if regex.NumSubexp() < 1 {
+			return compiledField{}, errors.New("regex needs a capture group")
+		}
+		field.regex = regex
+	}
+	return field, nil
+}
+
+func compileRule(rule StatusRule) (compiledRule, error) {
+	if rule.Status == "" {
+		return compiledRule{}, errors.New("rule is missing a status")
+	}
+	compiled := compiledRule{status: rule.Status}
+	if strings.TrimSpace(rule.When) == "" {
+		// a rule without a condition always applies
+		return compiled, nil
+	}
+	for _, part := range strings.Split(rule.When, "&&") {
+		tokens := strings.Fields(part)
+		if len(tokens) != 3 {
+			return compiledRule{}, fmt.Errorf("condition %q should look like \"field > 0\"", part)
+		}
+		c := condition{left: tokens[0], op: tokens[1], right: tokens[2]}
+		switch c.op {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return compiledRule{}, fmt.Errorf("unknown operator %q", c.op)
+		}
+		for _, operand := range []string{c.left, c.right} {
+			if _, err := strconv.Atoi(operand); err != nil && !numberFields[operand] {
+				return compiledRule{}, fmt.Errorf("%q is neither a number nor a number field", operand)
+			}
+		}
+		compiled.conditions = append(compiled.conditions, c)
+	}
+	return compiled, nil
+}
+
+func (s *Scraper) GetClassDetails(uri string) (ClassDetails, error) {
+	target, err := s.URL(uri)
+	if err != nil {
+		return ClassDetails{}, fmt.Errorf("%w: uri is invalid: %s", ErrInvalidClass, err)
+	}
+	resp, err := fetcher(s.Fetcher).Fetch(target)
+	if err != nil {
+		return ClassDetails{}, fmt.Errorf("fetching uri: %w", err)
+	}
+	details, err := s.parse(bytes.NewReader(resp.Body))
+	if err != nil {
+		return ClassDetails{}, &ParseError{URI: uri, Body: resp.Body, Err: err}
+	}
+	return details, nil
+}
+
+func (s *Scraper) Matches(uri string) bool {
+	return s.match != nil && s.match.MatchString(uri)
+}
+
+// ExampleURI returns the example class url of the definition
+func (s *Scraper) ExampleURI() string {
+	return s.Definition.Example
+}
+
+func (s *Scraper) CourseURI(course string) (string, error) {
+	if s.course == nil {
+		return "", errors.New("definition has no course identifiers")
+	}
+	groups := s.course.FindStringSubmatch(course)
+	if groups == nil {
+		return "", fmt.Errorf("%s does not match %s", course, s.Definition.Course.Pattern)
+	}
+	values := map[string]string{"course": course}
+	for i, name := range s.course.SubexpNames() {
+		if name != "" {
+			values[name] = groups[i]
+		}
+	}
+	uri, err := execute(s.courseURL, values)
+	if err != nil {
+		return "", fmt.Errorf("executing course url: %s", err)
+	}
+	return uri, nil
+}
+
+// BatchKey returns the group of uris listed on the same page as uri, or an empty
+// string when the definition has no batch listing
+func (s *Scraper) BatchKey(uri string) string {
+	if s.batch == nil {
+		return ""
+	}
+	values, err := s.values(uri)
+	if err != nil {
+		return ""
+	}
+	key, err := execute(s.batch.group, values)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// GetManyClassDetails fetches the listing shared by uris, which must all have the
+// same BatchKey, and returns the details of every uri found on it
+func (s *Scraper) GetManyClassDetails(uris []string) (map[string]ClassDetails, error) {
+	if s.batch == nil {
+		return nil, errors.New("definition has no batch listing")
+	}
+	if len(uris) == 0 {
+		return map[string]ClassDetails{}, nil
+	}
+
+	// keys maps the key of a row to the uris listed by it, which may be several
+	// when uris differ by parameters the key leaves out
+	keys := make(map[string][]string, len(uris))
+	var target string
+	for _, uri := range uris {
+		values, err := s.values(uri)
+		if err != nil {
+			return nil, fmt.Errorf("%w: uri is invalid: %s", ErrInvalidClass, err)
+		}
+		key, err := execute(s.batch.key, values)
+		if err != nil {
+			return nil, fmt.Errorf("executing key of %s: %s", uri, err)
+		}
+		keys[key] = append(keys[key], uri)
+		if target == "" {
+			if target, err = execute(s.batch.fetchURL, values); err != nil {
+				return nil, fmt.Errorf("executing batch fetch_url: %s", err)
+			}
+		}
+	}
+
+	resp, err := fetcher(s.Fetcher).Fetch(target)
+	if err != nil {
+		return nil, fmt.Errorf("fetching listing: %w", err)
+	}
+	rows, err := s.parseRows(bytes.NewReader(resp.Body))
+	if err != nil {
+		return nil, &ParseError{URI: target, Body: resp.Body, Err: err}
+	}
+	details := make(map[string]ClassDetails, len(uris))
+	for key, d := range rows {
+		for _, uri := range keys[key] {
+			details[uri] = d
+		}
+	}
+	return details, nil
+}
+
+// parseRows returns the details of every class of a listing, keyed by KeyField.
+// Malformed rows are left out unless no row could be parsed.
+func (s *Scraper) parseRows(body io.Reader) (map[string]ClassDetails, error) {
+	var extractors []func(name string, field compiledField) (string, error)
+	switch s.Definition.Format {
+	case "html":
+		doc, err := goquery.NewDocumentFromReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing body into html: %s", err)
+		}
+		doc.FindMatcher(s.batch.rows).Each(func(_ int, row *goquery.Selection) {
+			extractors = append(extractors, func(name string, field compiledField) (string, error) {
+				return extractHTML(row, name, field)
+			})
+		})
+	case "json":
+		var doc interface{}
+		if err := json.NewDecoder(body).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("parsing body into json: %s", err)
+		}
+		rows, err := lookupJSON(doc, s.Definition.Batch.Rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows: %s", err)
+		}
+		list, ok := rows.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("rows path %s is not an array", s.Definition.Batch.Rows)
+		}
+		for _, row := range list {
+			row := row
+			extractors = append(extractors, func(name string, field compiledField) (string, error) {
+				return extractJSON(row, name, field)
+			})
+		}
+	}
+
+	details := make(map[string]ClassDetails, len(extractors))
+	var malformed error
+	for i, extract := range extractors {
+		key, err := fieldText("key", s.batch.keyField, extract)
+		if err != nil {
+			// rows such as headers or notes have no key and are not classes
+			continue
+		}
+		d, err := s.details(s.batch.fields, extract)
+		if err != nil {
+			// the class of a malformed row is left out and checked on its own
+			if malformed == nil {
+				malformed = fmt.Errorf("row %d with key %s: %s", i, key, err)
+			}
+			continue
+		}
+		details[key] = d
+	}
+	if len(details) == 0 && malformed != nil {
+		return nil, malformed
+	}
+	if len(extractors) > 0 && len(details) == 0 {
+		return nil, fmt.Errorf("none of the %d rows has a key", len(extractors))
+	}
+	return details, nil
+}
+
+// URL returns the url that is fetched for a subscribed uri
+func (s *Scraper) URL(uri string) (string, error) {
+	values, err := s.values(uri)
+	if err != nil {
+		return "", err
+	}
+	if s.fetchURL == nil {
+		return uri, nil
+	}
+	target, err := execute(s.fetchURL, values)
+	if err != nil {
+		return "", fmt.Errorf("executing fetch_url: %s", err)
+	}
+	return target, nil
+}
+
+// values returns the named groups of Match in uri, along with uri itself
+func (s *Scraper) values(uri string) (map[string]string, error) {
+	values := map[string]string{"uri": uri}
+	if s.match == nil {
+		return values, nil
+	}
+	groups := s.match.FindStringSubmatch(uri)
+	if groups == nil {
+		return nil, fmt.Errorf("%s does not match %s", uri, s.match)
+	}
+	for i, name := range s.match.SubexpNames() {
+		if name != "" {
+			values[name] = groups[i]
+		}
+	}
+	return values, nil
+}
+
+func execute(tmpl *template.Template, values map[string]string) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (s *Scraper) parse(body io.Reader) (ClassDetails, error) {
+	var extract func(name string, field compiledField) (string, error)
+	switch s.Definition.Format {
+	case "html":
+		doc, err := goquery.NewDocumentFromReader(body)
+		if err != nil {
+			return ClassDetails{}, fmt.Errorf("parsing body into html: %s", err)
+		}
+		extract = func(name string, field compiledField) (string, error) {
+			return extractHTML(doc.Selection, name, field)
+		}
+	case "json":
+		var doc interface{}
+		if err := json.NewDecoder(body).Decode(&doc); err != nil {
+			return ClassDetails{}, fmt.Errorf("parsing body into json: %s", err)
+		}
+		extract = func(name string, field compiledField) (string, error) {
+			return extractJSON(doc, name, field)
+		}
+	}
+	return s.details(s.fields, extract)
+}
+
+// details builds ClassDetails out of the values of fields returned by extract
+func (s *Scraper) details(fields map[string]compiledField, extract func(name string, field compiledField) (string, error)) (ClassDetails, error) {
+	texts := make(map[string]string)
+	numbers := make(map[string]int)
+	for name, field := range fields {
+		text, err := fieldText(name, field, extract)
+		if err != nil {
+			return ClassDetails{}, err
+		}
+		if textFields[name] {
+			texts[name] = text
+			continue
+		}
+		n, err := parseNumber(text)
+		if err != nil {
+			return ClassDetails{}, fmt.Errorf("could not convert %s text %q to int: %s", name, text, err)
+		}
+		numbers[name] = n
+	}
+
+	fillRemaining(numbers, fieldSeatsTotal, fieldSeatsTaken, fieldSeatsRemaining)
+	fillRemaining(numbers, fieldWaitlistTotal, fieldWaitlistTaken, fieldWaitlistRemaining)
+
+	status := s.Definition.DefaultStatus
+	for _, rule := range s.status {
+		if rule.holds(numbers) {
+			status = rule.status
+			break
+		}
+	}
+
+	return ClassDetails{
+		Name:              texts[fieldName],
+		Description:       texts[fieldDescription],
+		Status:            status,
+		SeatsTotal:        numbers[fieldSeatsTotal],
+		SeatsRemaining:    numbers[fieldSeatsRemaining],
+		WaitlistTotal:     numbers[fieldWaitlistTotal],
+		WaitlistRemaining: numbers[fieldWaitlistRemaining],
+	}, nil
+}
+
+// fieldText returns the text of field returned by extract, narrowed down by the
+// regex of the field
+func fieldText(name string, field compiledField, extract func(name string, field compiledField) (string, error)) (string, error) {
+	text, err := extract(name, field)
+	if err != nil {
+		return "", err
+	}
+	if field.regex != nil {
+		groups := field.regex.FindStringSubmatch(text)
+		if groups == nil {
+			return "", fmt.Errorf("field %s value %q does not match %s", name, text, field.regex)
+		}
+		text = groups[1]
+	}
+	return strings.TrimSpace(text), nil
+}
+
+func extractHTML(doc *goquery.Selection, name string, field compiledField) (string, error) {
+	selection := doc.FindMatcher(field.selector).First()
+	if selection.Length() == 0 {
+		return "", fmt.Errorf("field %s selector %q matched nothing", name, field.Selector)
+	}
+	if field.Attr == "" {
+		return selection.Text(), nil
+	}
+	value, ok := selection.Attr(field.Attr)
+	if !ok {
+		return "", fmt.Errorf("field %s element has no attribute %s", name, field.Attr)
+	}
+	return value, nil
+}
+
+func extractJSON(doc interface{}, name string, field compiledField) (string, error) {
+	value, err := lookupJSON(doc, field.Path)
+	if err != nil {
+		return "", fmt.Errorf("field %s: %s", name, err)
+	}
+	switch v := value.(type) {
+	case string:
+		// registrar apis often return text escaped for the html page it ends up in
+		return html.UnescapeString(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// lookupJSON follows a dot separated path of keys and array indexes into doc
+func lookupJSON(doc interface{}, path string) (interface{}, error) {
+	value := doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("path %s has no key %s", path, key)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("path %s has no index %s", path, key)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("path %s cannot descend into %v", path, value)
+		}
+	}
+	return value, nil
+}
+
+// parseNumber accepts numbers such as "1,024" or " 12 "
+func parseNumber(text string) (int, error) {
+	text = strings.ReplaceAll(strings.TrimSpace(text), ",", "")
+	return strconv.Atoi(text)
+}
+
+// fillRemaining derives remaining from total and taken when the page does not show it
+func fillRemaining(numbers map[string]int, total, taken, remaining string) {
+	if _, ok := numbers[remaining]; ok {
+		return
+	}
+	t, hasTotal := numbers[total]
+	a, hasTaken := numbers[taken]
+	if hasTotal && hasTaken {
+		numbers[remaining] = t - a
+	}
+}
+
+func (r compiledRule) holds(numbers map[string]int) bool {
+	for _, c := range r.conditions {
+		left, right := operand(numbers, c.left), operand(numbers, c.right)
+		var ok bool
+		switch c.op {
+		case "<":
+			ok = left < right
+		case "<=":
+			ok = left <= right
+		case ">":
+			ok = left > right
+		case ">=":
+			ok = left >= right
+		case "==":
+			ok = left == right
+		case "!=":
+			ok = left != right
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func operand(numbers map[string]int, token string) int {
+	if n, err := strconv.Atoi(token); err == nil {
+		return n
+	}
+	return numbers[token]
+}
@@ -0,0 +1,141 @@
+package schools
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// listingFetcher answers every fetch with body and records the fetched urls
+type listingFetcher struct {
+	body    string
+	fetched []string
+}
+
+func (f *listingFetcher) Fetch(uri string) (*Response, error) {
+	f.fetched = append(f.fetched, uri)
+	return &Response{URI: uri, StatusCode: 200, Body: []byte(f.body)}, nil
+}
+
+const bannerClass = "https://registration.banner.gatech.edu/StudentRegistrationSsb/ssb/searchResults/searchResults?txt_term=202208&txt_subject=CS&txt_courseReferenceNumber="
+
+func TestGetManyClassDetails(t *testing.T) {
+	// row is a class of the listing with 200 seats and a full waitlist
+	row := func(crn string, title string, enrollment string, available string) string {
+		return `{"courseReferenceNumber": "` + crn + `", "courseTitle": "` + title + `", "maximumEnrollment": 200, "enrollment": ` + enrollment +
+			`, "seatsAvailable": ` + available + `, "waitCapacity": 40, "waitCount": 40, "waitAvailable": 0}`
+	}
+	tests := []struct {
+		test string
+		body string
+		crns []string
+		want map[string]ClassStatus
+		// name is the expected name of the first class
+		name    string
+		wantErr bool
+	}{
+		{
+			test: "many rows",
+			body: `{"data": [` + row("87695", "Data Struct &amp; Algorithms", "200", "0") + `, ` + row("80123", "Intro", "150", "50") + `, ` + row("81000", "Other", "0", "200") + `]}`,
+			crns: []string{"87695", "80123"},
+			want: map[string]ClassStatus{"87695": FULL, "80123": OPENED},
+			name: "Data Struct & Algorithms",
+		},
+		{
+			test: "class missing from the listing",
+			body: `{"data": [` + row("87695", "Data Struct", "200", "0") + `]}`,
+			crns: []string{"87695", "80123"},
+			want: map[string]ClassStatus{"87695": FULL},
+		},
+		{
+			test: "malformed row",
+			body: `{"data": [` + row("87695", "Data Struct", `"many"`, "0") + `, ` + row("80123", "Intro", "150", "50") + `, {"note": "no key"}]}`,
+			crns: []string{"87695", "80123"},
+			want: map[string]ClassStatus{"80123": OPENED},
+		},
+		{
+			test:    "every row malformed",
+			body:    `{"data": [` + row("87695", "Data Struct", `"many"`, "0") + `]}`,
+			crns:    []string{"87695"},
+			wantErr: true,
+		},
+		{
+			test:    "no rows",
+			body:    `{"results": []}`,
+			crns:    []string{"87695"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.test, func(t *testing.T) {
+			scraper, err := LoadDefinition(filepath.Join("..", "scrapers", "georgia_tech_banner.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fetcher := &listingFetcher{body: tt.body}
+			scraper.Fetcher = fetcher
+			uris := make([]string, len(tt.crns))
+			for i, crn := range tt.crns {
+				uris[i] = bannerClass + crn
+				if key := scraper.BatchKey(uris[i]); key != "202208/CS" {
+					t.Fatalf("batch key of %s = %q", uris[i], key)
+				}
+			}
+
+			details, err := scraper.GetManyClassDetails(uris)
+			if len(fetcher.fetched) != 1 {
+				t.Errorf("fetched %v, want the listing once", fetcher.fetched)
+			}
+			if tt.wantErr {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) {
+					t.Errorf("error = %v, want a parse error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(details) != len(tt.want) {
+				t.Errorf("details = %v, want %v", details, tt.want)
+			}
+			for crn, status := range tt.want {
+				if d := details[bannerClass+crn]; d.Status != status {
+					t.Errorf("details of %s = %+v, want %s", crn, d, status)
+				}
+			}
+			if d := details[uris[0]]; tt.name != "" && d.Name != tt.name {
+				t.Errorf("name = %q, want %q", d.Name, tt.name)
+			}
+		})
+	}
+}
+
+func TestGetManyClassDetailsKeys(t *testing.T) {
+	scraper, err := LoadDefinition(filepath.Join("..", "scrapers", "georgia_tech_banner.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the listing writes its keys as "CRN 87695", and classes may be subscribed to
+	// with parameters the key leaves out
+	def := scraper.Definition
+	def.Match = strings.TrimSuffix(def.Match, "$") + `(?:&.*)?$`
+	def.Batch.KeyField.Regex = `CRN (\d+)`
+	if scraper, err = Compile(def); err != nil {
+		t.Fatal(err)
+	}
+	scraper.Fetcher = &listingFetcher{body: `{"data": [{"courseReferenceNumber": "CRN 87695", "courseTitle": "Data Struct", "maximumEnrollment": 200,
+		"enrollment": 150, "seatsAvailable": 50, "waitCapacity": 40, "waitCount": 40, "waitAvailable": 0}]}`}
+
+	uris := []string{bannerClass + "87695", bannerClass + "87695&pageOffset=0"}
+	details, err := scraper.GetManyClassDetails(uris)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uri := range uris {
+		if d, ok := details[uri]; !ok || d.Status != OPENED {
+			t.Errorf("details of %s = %+v, want the class found by its key", uri, d)
+		}
+	}
+}
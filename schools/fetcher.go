@@ -0,0 +1,202 @@
+package schools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+)
+
+// Fetcher downloads registrar pages on behalf of an ISchool
+type Fetcher interface {
+	Fetch(uri string) (*Response, error)
+}
+
+type Response struct {
+	URI        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+const DefaultUserAgent = "class-notify/1.0 (+https://github.com/zMrKrabz/class-notify)"
+
+// DefaultFetcher is used by schools that were not given a Fetcher
+var DefaultFetcher Fetcher = &HTTPFetcher{
+	Client:    &http.Client{Timeout: 30 * time.Second},
+	UserAgent: DefaultUserAgent,
+	Retries:   2,
+	Backoff:   time.Second,
+}
+
+func fetcher(f Fetcher) Fetcher {
+	if f == nil {
+		return DefaultFetcher
+	}
+	return f
+}
+
+type FetcherOptions struct {
+	// Timeout bounds a single attempt, including reading the body
+	Timeout time.Duration
+	// Retries is the number of extra attempts made after a server error or timeout
+	Retries int
+	// Backoff is the wait before the first retry, doubled on every following one
+	Backoff   time.Duration
+	UserAgent string
+	// Proxy is the url of the proxy to use, the environment's proxy is used when empty
+	Proxy string
+	// Cookies keeps cookies set by the registrar between requests
+	Cookies bool
+}
+
+// HTTPFetcher fetches pages over HTTP, retrying on server errors and timeouts
+type HTTPFetcher struct {
+	Client    *http.Client
+	UserAgent string
+	Retries   int
+	Backoff   time.Duration
+}
+
+func NewHTTPFetcher(opts FetcherOptions) (*HTTPFetcher, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy url %s: %s", opts.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+	}
+	if opts.Cookies {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, fmt.Errorf("creating cookie jar: %s", err)
+		}
+		client.Jar = jar
+	}
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return &HTTPFetcher{
+		Client:    client,
+		UserAgent: userAgent,
+		Retries:   opts.Retries,
+		Backoff:   opts.Backoff,
+	}, nil
+}
+
+// StatusError is returned when the registrar answers with a non 2xx status
+type StatusError struct {
+	URI        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("getting %s: unexpected status %s", e.URI, e.Status)
+}
+
+// Temporary reports whether retrying the request later may succeed
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsTemporary reports whether err is a failure that may go away on its own, such
+// as a timeout or a server error, rather than a bad uri
+func IsTemporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// IsUnavailable reports whether err means a school could not be reached or is
+// failing, rather than answering that a page does not exist
+func IsUnavailable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (f *HTTPFetcher) Fetch(uri string) (*Response, error) {
+	return f.retry(uri, nil)
+}
+
+// FetchWithHeader fetches uri, adding header to the request. Conditional requests
+// made this way return a Response with http.StatusNotModified when nothing changed.
+func (f *HTTPFetcher) FetchWithHeader(uri string, header http.Header) (*Response, error) {
+	return f.retry(uri, header)
+}
+
+// retry fetches uri with header until it succeeds, fails for good or runs out of retries
+func (f *HTTPFetcher) retry(uri string, header http.Header) (*Response, error) {
+	backoff := f.Backoff
+	var err error
+	for attempt := 0; attempt <= f.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var resp *Response
+		resp, err = f.fetch(uri, header)
+		if err == nil {
+			return resp, nil
+		}
+		if !IsTemporary(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", f.Retries+1, err)
+}
+
+func (f *HTTPFetcher) fetch(uri string, header http.Header) (*Response, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	// 304 is only answered to conditional requests, which expect it
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != http.StatusNotModified {
+		return nil, &StatusError{URI: uri, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return &Response{
+		URI:        uri,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
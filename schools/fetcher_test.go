@@ -0,0 +1,105 @@
+package schools
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcherRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are answered in turn, the last one for every following attempt
+		statuses  []int
+		attempts  int32
+		status    int
+		temporary bool
+	}{
+		{"success", []int{http.StatusOK}, 1, 0, false},
+		{"server error then success", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, 0, false},
+		{"server errors", []int{http.StatusBadGateway}, 3, http.StatusBadGateway, true},
+		{"rate limited", []int{http.StatusTooManyRequests}, 3, http.StatusTooManyRequests, true},
+		{"not found", []int{http.StatusNotFound}, 1, http.StatusNotFound, false},
+		{"forbidden", []int{http.StatusForbidden}, 1, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				if r.Header.Get("User-Agent") != "test-agent" {
+					t.Errorf("user agent = %q", r.Header.Get("User-Agent"))
+				}
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+				w.Write([]byte("page"))
+			}))
+			defer server.Close()
+			f := &HTTPFetcher{Client: server.Client(), UserAgent: "test-agent", Retries: 2, Backoff: time.Millisecond}
+
+			resp, err := f.Fetch(server.URL)
+			if attempts.Load() != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts.Load(), tt.attempts)
+			}
+			if tt.status == 0 {
+				if err != nil || string(resp.Body) != "page" {
+					t.Errorf("fetch = %v %v, want the page", resp, err)
+				}
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Fatalf("error = %v, want a status error %d", err, tt.status)
+			}
+			if IsTemporary(err) != tt.temporary || IsUnavailable(err) != tt.temporary {
+				t.Errorf("error %v temporary = %t, want %t", err, IsTemporary(err), tt.temporary)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	f, err := NewHTTPFetcher(FetcherOptions{Timeout: 20 * time.Millisecond, Retries: 1, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Fetch(server.URL)
+	if err == nil || !IsTemporary(err) || !IsUnavailable(err) {
+		t.Errorf("error = %v, want a temporary timeout", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("attempts = %d, want the timeout retried once", attempts.Load())
+	}
+}
+
+func TestHTTPFetcherNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+	f := &HTTPFetcher{Client: server.Client(), Retries: 2}
+
+	resp, err := f.FetchWithHeader(server.URL, http.Header{"If-None-Match": {`"v1"`}})
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional fetch = %v %v, want 304 without an error", resp, err)
+	}
+}
@@ -0,0 +1,91 @@
+package schools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fixture is a recorded registrar page along with the ClassDetails a school is
+// expected to parse out of it. Fixtures live in testdata/<school id>/<name>.golden.json
+// next to the recorded body.
+type Fixture struct {
+	// URI is the uri a user would subscribe to
+	URI string `json:"uri"`
+	// Body is the file name of the recorded response, relative to the fixture
+	Body        string       `json:"body"`
+	ContentType string       `json:"content_type"`
+	Details     ClassDetails `json:"details"`
+}
+
+// RecordFixture downloads target, the page fetched for uri, with f and saves it to
+// dir as a new fixture called name. The golden details are left empty, they are
+// filled in by running the fixture tests with -update.
+func RecordFixture(f Fetcher, dir string, name string, uri string, target string) (Fixture, error) {
+	resp, err := fetcher(f).Fetch(target)
+	if err != nil {
+		return Fixture{}, fmt.Errorf("fetching %s: %s", target, err)
+	}
+	body := resp.Body
+
+	contentType := resp.Header.Get("Content-Type")
+	ext := ".html"
+	if strings.Contains(contentType, "json") {
+		ext = ".json"
+	}
+	fixture := Fixture{
+		URI:         uri,
+		Body:        name + ext,
+		ContentType: contentType,
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Fixture{}, fmt.Errorf("creating fixture directory %s: %s", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fixture.Body), body, 0o644); err != nil {
+		return Fixture{}, fmt.Errorf("writing fixture body: %s", err)
+	}
+	if err := fixture.WriteGolden(dir, name); err != nil {
+		return Fixture{}, err
+	}
+	return fixture, nil
+}
+
+// WriteGolden saves the fixture as dir/name.golden.json
+func (f Fixture) WriteGolden(dir string, name string) error {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(f); err != nil {
+		return fmt.Errorf("encoding fixture %s: %s", name, err)
+	}
+	path := filepath.Join(dir, name+".golden.json")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing golden file %s: %s", path, err)
+	}
+	return nil
+}
+
+// LoadFixtures reads every fixture in dir, keyed by name
+func LoadFixtures(dir string) (map[string]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.golden.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing fixtures in %s: %s", dir, err)
+	}
+	fixtures := make(map[string]Fixture, len(paths))
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading fixture %s: %s", path, err)
+		}
+		var f Fixture
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("decoding fixture %s: %s", path, err)
+		}
+		fixtures[strings.TrimSuffix(filepath.Base(path), ".golden.json")] = f
+	}
+	return fixtures, nil
+}
@@ -0,0 +1,102 @@
+package schools
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the details parsed from fixtures")
+
+// fixtureSchools returns every ISchool implementation under test using fetcher,
+// keyed by the testdata directory holding its fixtures
+func fixtureSchools(t *testing.T, fetcher Fetcher) map[string]ISchool {
+	schools := map[string]ISchool{
+		"GEORGIA_TECH": &GeorgiaTech{Fetcher: fetcher},
+	}
+	scrapers, err := LoadDefinitions(filepath.Join("..", "scrapers"))
+	if err != nil {
+		t.Fatalf("loading scraper definitions: %s", err)
+	}
+	for _, s := range scrapers {
+		s.Fetcher = fetcher
+		schools[s.Definition.ID] = s
+	}
+	return schools
+}
+
+// redirectTransport sends every request to server, whatever host it was made for
+type redirectTransport struct {
+	server *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.server.Scheme
+	req.URL.Host = rt.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// serveFixture returns a fetcher that answers every request with the fixture body
+func serveFixture(t *testing.T, dir string, f Fixture) Fetcher {
+	body, err := os.ReadFile(filepath.Join(dir, f.Body))
+	if err != nil {
+		t.Fatalf("reading fixture body: %s", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.ContentType != "" {
+			w.Header().Set("Content-Type", f.ContentType)
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parsing test server url: %s", err)
+	}
+	return &HTTPFetcher{
+		Client:    &http.Client{Transport: redirectTransport{server: serverURL}},
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	for id := range fixtureSchools(t, nil) {
+		id := id
+		t.Run(id, func(t *testing.T) {
+			dir := filepath.Join("testdata", id)
+			fixtures, err := LoadFixtures(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fixtures) == 0 {
+				t.Fatalf("no fixtures in %s, record some with `cli record`", dir)
+			}
+			for name, f := range fixtures {
+				name, f := name, f
+				t.Run(name, func(t *testing.T) {
+					school := fixtureSchools(t, serveFixture(t, dir, f))[id]
+					details, err := school.GetClassDetails(f.URI)
+					if err != nil {
+						t.Fatalf("getting class details of %s: %s", f.URI, err)
+					}
+					if *update {
+						f.Details = details
+						if err := f.WriteGolden(dir, name); err != nil {
+							t.Fatal(err)
+						}
+						return
+					}
+					if details != f.Details {
+						t.Errorf("details of %s\n got: %s\nwant: %s", f.URI, details, f.Details)
+					}
+				})
+			}
+		})
+	}
+}
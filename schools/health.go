@@ -0,0 +1,246 @@
+package schools
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ParseError is returned when a page was downloaded but could not be turned into
+// ClassDetails, which usually means the registrar changed its page layout.
+type ParseError struct {
+	URI  string
+	Body []byte
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parsing response body of %s: %s", e.URI, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// fingerprintDepth bounds how deep Fingerprint looks, so that the number of rows
+// in a table does not change the fingerprint of an otherwise identical page
+const fingerprintDepth = 8
+
+// Fingerprint summarises the element structure of an html page. Pages rendered
+// from the same template share a fingerprint, so a change in fingerprint across
+// many failing pages points at a redesign.
+func Fingerprint(body []byte) string {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	h := sha1.New()
+	var walk func(n *html.Node, depth int)
+	walk = func(n *html.Node, depth int) {
+		if depth > fingerprintDepth {
+			return
+		}
+		var previous string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			// runs of the same sibling count once
+			if c.Data == previous {
+				continue
+			}
+			previous = c.Data
+			fmt.Fprintf(h, "%d:%s;", depth, c.Data)
+			walk(c, depth+1)
+		}
+	}
+	walk(doc, 0)
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// healthWindow is the number of most recent checks a failure rate is computed over
+const healthWindow = 50
+
+// Health tracks how well each school's scraper is doing and raises an alert when
+// parsing keeps failing across many events.
+type Health struct {
+	// Threshold is the number of distinct events that must fail to parse in a row
+	// before Alert is called
+	Threshold int
+	// Alert is called once when a scraper starts failing and once when it recovers
+	Alert func(alert HealthAlert)
+
+	mu      sync.Mutex
+	schools map[string]*schoolHealth
+}
+
+type schoolHealth struct {
+	status  SchoolHealth
+	results []bool
+	failing map[string]bool
+	body    []byte
+	uri     string
+	alerted bool
+	// failures counts the parse failures since the last success
+	failures int
+}
+
+// SchoolHealth is a snapshot of a school's scraper health
+type SchoolHealth struct {
+	School              string
+	Checks              int
+	ParseFailures       int
+	FetchFailures       int
+	ConsecutiveFailures int
+	// FailureRate is the share of failed checks among the most recent ones
+	FailureRate float64
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string
+	// Fingerprints counts the page fingerprints seen while failing to parse
+	Fingerprints map[string]int
+}
+
+// Failing reports whether the scraper is currently failing across many events
+func (sh SchoolHealth) Failing(threshold int) bool {
+	return sh.ConsecutiveFailures >= threshold
+}
+
+type HealthAlert struct {
+	SchoolHealth
+	// Recovered is set when the scraper parsed a page again after an alert
+	Recovered     bool
+	FailingEvents int
+	// Failures counts the parse failures since the scraper last parsed a page,
+	// those of the outage that ended for a recovery
+	Failures int
+	// URI and Body are those of the most recent page that failed to parse
+	URI         string
+	Body        []byte
+	Fingerprint string
+}
+
+func NewHealth(threshold int, alert func(alert HealthAlert)) *Health {
+	return &Health{
+		Threshold: threshold,
+		Alert:     alert,
+		schools:   make(map[string]*schoolHealth),
+	}
+}
+
+// Record stores the outcome of fetching uri with school
+func (h *Health) Record(school string, uri string, err error) {
+	h.mu.Lock()
+	sh, ok := h.schools[school]
+	if !ok {
+		sh = &schoolHealth{
+			status: SchoolHealth{
+				School:       school,
+				Fingerprints: make(map[string]int),
+			},
+			failing: make(map[string]bool),
+		}
+		h.schools[school] = sh
+	}
+
+	now := time.Now()
+	sh.status.Checks++
+	sh.results = append(sh.results, err == nil)
+	if len(sh.results) > healthWindow {
+		sh.results = sh.results[len(sh.results)-healthWindow:]
+	}
+	failed := 0
+	for _, ok := range sh.results {
+		if !ok {
+			failed++
+		}
+	}
+	sh.status.FailureRate = float64(failed) / float64(len(sh.results))
+
+	var alert *HealthAlert
+	var parseErr *ParseError
+	switch {
+	case err == nil:
+		sh.status.LastSuccess = now
+		sh.status.ConsecutiveFailures = 0
+		sh.status.Fingerprints = make(map[string]int)
+		sh.failing = make(map[string]bool)
+		if sh.alerted {
+			sh.alerted = false
+			alert = &HealthAlert{SchoolHealth: sh.snapshot(), Recovered: true, Failures: sh.failures}
+		}
+		sh.failures = 0
+	case errors.As(err, &parseErr):
+		sh.status.ParseFailures++
+		sh.failures++
+		sh.status.ConsecutiveFailures++
+		sh.status.LastFailure = now
+		sh.status.LastError = err.Error()
+		sh.status.Fingerprints[Fingerprint(parseErr.Body)]++
+		sh.failing[uri] = true
+		sh.body = parseErr.Body
+		sh.uri = uri
+		if !sh.alerted && h.Threshold > 0 && len(sh.failing) >= h.Threshold {
+			sh.alerted = true
+			alert = &HealthAlert{
+				SchoolHealth:  sh.snapshot(),
+				FailingEvents: len(sh.failing),
+				Failures:      sh.failures,
+				URI:           sh.uri,
+				Body:          sh.body,
+				Fingerprint:   Fingerprint(sh.body),
+			}
+		}
+	default:
+		// the registrar being unreachable says nothing about the scraper itself
+		sh.status.FetchFailures++
+		sh.status.LastFailure = now
+		sh.status.LastError = err.Error()
+	}
+	h.mu.Unlock()
+
+	if alert != nil && h.Alert != nil {
+		h.Alert(*alert)
+	}
+}
+
+func (sh *schoolHealth) snapshot() SchoolHealth {
+	status := sh.status
+	status.Fingerprints = make(map[string]int, len(sh.status.Fingerprints))
+	for k, v := range sh.status.Fingerprints {
+		status.Fingerprints[k] = v
+	}
+	return status
+}
+
+// Snapshot returns the health of every school that has been checked, sorted by school
+func (h *Health) Snapshot() []SchoolHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshots := make([]SchoolHealth, 0, len(h.schools))
+	for _, sh := range h.schools {
+		snapshots = append(snapshots, sh.snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].School < snapshots[j].School
+	})
+	return snapshots
+}
+
+// LastFailedPage returns the uri and body of the most recent page of school that
+// failed to parse
+func (h *Health) LastFailedPage(school string) (string, []byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sh, ok := h.schools[school]
+	if !ok || sh.body == nil {
+		return "", nil, false
+	}
+	return sh.uri, sh.body, true
+}
@@ -0,0 +1,126 @@
+package schools
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// check is the outcome of fetching a class: a parse error of body, a fetch error
+// when fetchErr is set, or a success
+type check struct {
+	uri      string
+	body     string
+	fetchErr bool
+}
+
+func (c check) err() error {
+	switch {
+	case c.fetchErr:
+		return errors.New("connection refused")
+	case c.body != "":
+		return &ParseError{URI: c.uri, Body: []byte(c.body), Err: errors.New("no seats")}
+	}
+	return nil
+}
+
+const (
+	oldLayout = "<html><body><table><tr><td>1</td></tr></table></body></html>"
+	newLayout = "<html><body><div><span>1</span></div></body></html>"
+)
+
+func TestHealthRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []check
+		// alerts are the FailingEvents of every alert raised, -1 for a recovery
+		alerts []int
+	}{
+		{
+			name:   "crossing the threshold",
+			checks: []check{{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}, {uri: "d", body: newLayout}},
+			alerts: []int{3},
+		},
+		{
+			name:   "same class failing again",
+			checks: []check{{uri: "a", body: newLayout}, {uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "b", body: newLayout}},
+		},
+		{
+			name:   "fetch failures",
+			checks: []check{{uri: "a", fetchErr: true}, {uri: "b", fetchErr: true}, {uri: "c", fetchErr: true}},
+		},
+		{
+			name:   "success in between",
+			checks: []check{{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c"}, {uri: "d", body: newLayout}},
+		},
+		{
+			name:   "recovery",
+			checks: []check{{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}, {uri: "a"}, {uri: "b"}},
+			alerts: []int{3, -1},
+		},
+		{
+			name: "failing again after recovering",
+			checks: []check{{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}, {uri: "a"},
+				{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}},
+			alerts: []int{3, -1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var alerts []int
+			health := NewHealth(3, func(alert HealthAlert) {
+				if alert.Recovered {
+					alerts = append(alerts, -1)
+				} else {
+					alerts = append(alerts, alert.FailingEvents)
+				}
+			})
+			for _, c := range tt.checks {
+				health.Record("TEST", c.uri, c.err())
+			}
+			if fmt.Sprint(alerts) != fmt.Sprint(tt.alerts) {
+				t.Errorf("alerts = %v, want %v", alerts, tt.alerts)
+			}
+		})
+	}
+}
+
+func TestHealthRecoveryFailures(t *testing.T) {
+	var alerts []HealthAlert
+	health := NewHealth(3, func(alert HealthAlert) { alerts = append(alerts, alert) })
+	for _, c := range []check{
+		{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}, {uri: "a"},
+		{uri: "a", body: newLayout}, {uri: "b", body: newLayout}, {uri: "b", body: newLayout}, {uri: "c", body: newLayout}, {uri: "a"},
+	} {
+		health.Record("TEST", c.uri, c.err())
+	}
+	if len(alerts) != 4 || !alerts[3].Recovered {
+		t.Fatalf("alerts = %+v, want 2 alerts each followed by a recovery", alerts)
+	}
+	if alerts[1].Failures != 3 || alerts[3].Failures != 4 {
+		t.Errorf("recovered after %d and %d failures, want 3 and 4, those of each outage", alerts[1].Failures, alerts[3].Failures)
+	}
+}
+
+func TestHealthFingerprints(t *testing.T) {
+	var alert HealthAlert
+	health := NewHealth(3, func(a HealthAlert) { alert = a })
+	health.Record("TEST", "a", check{uri: "a", body: newLayout}.err())
+	health.Record("TEST", "b", check{uri: "b", body: oldLayout}.err())
+	health.Record("TEST", "c", check{uri: "c", body: newLayout}.err())
+
+	if len(alert.Fingerprints) != 2 || alert.Fingerprints[Fingerprint([]byte(newLayout))] != 2 {
+		t.Errorf("fingerprints = %v, want the 2 layouts counted apart", alert.Fingerprints)
+	}
+	if alert.URI != "c" || alert.Fingerprint != Fingerprint([]byte(newLayout)) {
+		t.Errorf("alert = %s %s, want the last failing page", alert.URI, alert.Fingerprint)
+	}
+	// the number of rows does not change the layout
+	rows := "<html><body><table><tr><td>1</td></tr><tr><td>2</td></tr></table></body></html>"
+	if Fingerprint([]byte(rows)) != Fingerprint([]byte(oldLayout)) {
+		t.Error("pages differing by their number of rows have different fingerprints")
+	}
+	if snapshot := health.Snapshot(); len(snapshot) != 1 || snapshot[0].ParseFailures != 3 || snapshot[0].FailureRate != 1 {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+}
@@ -0,0 +1,173 @@
+package schools
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrUnknownSchool = errors.New("schools: no school handles this class")
+
+// ErrAmbiguousSchool is returned when registering a school that matches the class
+// urls of a school already registered
+var ErrAmbiguousSchool = errors.New("schools: school matches the classes of another")
+
+// ErrInvalidClass is wrapped by errors about urls and course identifiers a school
+// does not understand
+var ErrInvalidClass = errors.New("schools: invalid class")
+
+// Matcher is implemented by schools that can tell whether a class url is theirs
+type Matcher interface {
+	Matches(uri string) bool
+}
+
+// Exampler is implemented by schools that can give an example of their class urls,
+// which lets a Registry refuse schools claiming the same urls
+type Exampler interface {
+	ExampleURI() string
+}
+
+// CourseResolver is implemented by schools that accept a course identifier, such
+// as a term and CRN, in place of a class url
+type CourseResolver interface {
+	CourseURI(course string) (string, error)
+}
+
+// Canonicalizer is implemented by schools whose classes can be written as several
+// urls, such as with their query parameters in another order. CanonicalURI returns
+// the single url a class is stored and checked under.
+type Canonicalizer interface {
+	CanonicalURI(uri string) (string, error)
+}
+
+// Registry holds every school a bot serves
+type Registry struct {
+	// Default handles course identifiers without a school and events stored before
+	// events were tagged with their school
+	Default string
+
+	ids     []string
+	schools map[string]registered
+}
+
+type registered struct {
+	school ISchool
+	poll   time.Duration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{schools: make(map[string]registered)}
+}
+
+// Register adds school under id, checked every poll. The first school registered
+// becomes the default one. Registering a school whose example url is matched by
+// another one, or the other way around, fails with ErrAmbiguousSchool since
+// Resolve could not tell them apart.
+func (r *Registry) Register(id string, school ISchool, poll time.Duration) error {
+	if school == nil {
+		return fmt.Errorf("school %s is nil", id)
+	}
+	if _, ok := r.schools[id]; ok {
+		return fmt.Errorf("school %s is already registered", id)
+	}
+	for _, other := range r.ids {
+		if overlaps(school, r.schools[other].school) || overlaps(r.schools[other].school, school) {
+			return fmt.Errorf("%w: %s and %s match the same class urls", ErrAmbiguousSchool, id, other)
+		}
+	}
+	r.ids = append(r.ids, id)
+	r.schools[id] = registered{school: school, poll: poll}
+	if r.Default == "" {
+		r.Default = id
+	}
+	return nil
+}
+
+// overlaps reports whether m matches the example url of school
+func overlaps(school ISchool, m ISchool) bool {
+	example, ok := school.(Exampler)
+	if !ok || example.ExampleURI() == "" {
+		return false
+	}
+	matcher, ok := m.(Matcher)
+	return ok && matcher.Matches(example.ExampleURI())
+}
+
+func (r *Registry) Get(id string) (ISchool, bool) {
+	s, ok := r.schools[id]
+	return s.school, ok
+}
+
+// PollInterval returns the wait between two checks of the classes of school id
+func (r *Registry) PollInterval(id string) time.Duration {
+	return r.schools[id].poll
+}
+
+// IDs returns the id of every school in the order they were registered
+func (r *Registry) IDs() []string {
+	ids := make([]string, len(r.ids))
+	copy(ids, r.ids)
+	return ids
+}
+
+// Resolve finds the school handling target and the url of the class to check.
+// target is either a class url, a course identifier prefixed with a school id such
+// as "GEORGIA_TECH:202208/80123", or a course identifier of defaultSchool. The
+// registry's Default is used when defaultSchool is empty.
+func (r *Registry) Resolve(target string, defaultSchool string) (string, string, error) {
+	target = strings.TrimSpace(target)
+	if defaultSchool == "" {
+		defaultSchool = r.Default
+	}
+
+	if u, err := url.Parse(target); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		for _, id := range r.ids {
+			if m, ok := r.schools[id].school.(Matcher); ok && m.Matches(target) {
+				return r.canonical(id, target)
+			}
+		}
+		// schools that cannot tell their urls apart take whatever is left
+		if s, ok := r.schools[defaultSchool]; ok {
+			if _, ok := s.school.(Matcher); !ok {
+				return defaultSchool, target, nil
+			}
+		}
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownSchool, target)
+	}
+
+	id, course := defaultSchool, target
+	if i := strings.Index(target, ":"); i >= 0 {
+		if _, ok := r.schools[target[:i]]; ok {
+			id, course = target[:i], target[i+1:]
+		}
+	}
+	s, ok := r.schools[id]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownSchool, target)
+	}
+	resolver, ok := s.school.(CourseResolver)
+	if !ok {
+		return "", "", fmt.Errorf("%w: school %s only accepts class urls", ErrInvalidClass, id)
+	}
+	uri, err := resolver.CourseURI(course)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: resolving course %s of %s: %s", ErrInvalidClass, course, id, err)
+	}
+	return r.canonical(id, uri)
+}
+
+// canonical returns uri as school id stores it, so that a class subscribed to by
+// different urls or by course identifier is a single event
+func (r *Registry) canonical(id string, uri string) (string, string, error) {
+	c, ok := r.schools[id].school.(Canonicalizer)
+	if !ok {
+		return id, uri, nil
+	}
+	canonical, err := c.CanonicalURI(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidClass, err)
+	}
+	return id, canonical, nil
+}
@@ -0,0 +1,42 @@
+package schools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	const oscar = "https://oscar.gatech.edu/bprod/bwckschd.p_disp_detail_sched?term_in=202208&crn_in=80123"
+	tests := []struct {
+		name    string
+		target  string
+		want    string
+		wantErr error
+	}{
+		{"pasted url", oscar, oscar, nil},
+		{"parameters in another order", "https://oscar.gatech.edu/bprod/bwckschd.p_disp_detail_sched?crn_in=80123&term_in=202208", oscar, nil},
+		{"extra parameters", oscar + "&utm_source=mail", oscar, nil},
+		{"course identifier", "202208/80123", oscar, nil},
+		{"course identifier of a school", "GEORGIA_TECH:202208/80123", oscar, nil},
+		{"url of no school", "https://example.com/class?crn=80123", "", ErrUnknownSchool},
+		{"invalid course", "202208-80123", "", ErrInvalidClass},
+	}
+	registry := NewRegistry()
+	if err := registry.Register("GEORGIA_TECH", &GeorgiaTech{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, uri, err := registry.Resolve(tt.target, "")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || id != "GEORGIA_TECH" || uri != tt.want {
+				t.Errorf("resolved to %s %s %v, want %s", id, uri, err, tt.want)
+			}
+		})
+	}
+}
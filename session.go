@@ -0,0 +1,21 @@
+package class_notify
+
+import "github.com/bwmarrin/discordgo"
+
+// Session is the part of a discord session the bot uses to answer interactions,
+// send alerts and manage its commands. *discordgo.Session implements it.
+type Session interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit) (*discordgo.Message, error)
+
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+	UserChannelPermissions(userID string, channelID string) (int64, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+
+	ApplicationCommands(appID string, guildID string) ([]*discordgo.ApplicationCommand, error)
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand) ([]*discordgo.ApplicationCommand, error)
+	UserGuilds(limit int, beforeID string, afterID string) ([]*discordgo.UserGuild, error)
+}
+
+var _ Session = (*discordgo.Session)(nil)
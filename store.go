@@ -0,0 +1,51 @@
+package class_notify
+
+import (
+	"github.com/zMrKrabz/class-notify/schools"
+	"time"
+)
+
+// Store persists events and guild settings. *Database implements it.
+type Store interface {
+	GetAllActiveEvents(school string, isDefault bool) ([]Event, error)
+	GetActiveEventsCount(school string, isDefault bool) (int64, error)
+	GetActiveSubscribersCount(school string, isDefault bool) (int64, error)
+	GetEventWithURI(uri string) (Event, error)
+	GetEventsWithSubscriber(userID string) ([]Event, error)
+
+	CreateEvent(event Event) (Event, error)
+	AddSubscriber(uri string, subscriberID string) error
+	RemoveSubscriber(uri string, subscriberID string) error
+	AddChannel(uri string, target ChannelTarget) error
+	RemoveChannel(uri string, guildID string, channelID string) error
+	RemoveEvent(uri string) error
+	UpdateEventDetails(uri string, details schools.ClassDetails) error
+	UpdateEventNotification(uri string, notified schools.ClassDetails, pending *Pending) error
+
+	GetGuildSettings(guildID string) (GuildSettings, error)
+	SaveGuildSettings(settings GuildSettings) error
+
+	GetPreferences(userID string) (Preferences, error)
+	SavePreferences(preferences Preferences) error
+	// GetPreferencesWithFeedToken returns the preferences of the user whose feed has
+	// token, or ErrNoSuchFeed
+	GetPreferencesWithFeedToken(token string) (Preferences, error)
+	QueueAlert(alert QueuedAlert) error
+	// ClaimDueAlerts returns the queued alerts due at now that no one is delivering,
+	// claiming them for lease. Alerts whose lease ran out are claimed again.
+	ClaimDueAlerts(now time.Time, lease time.Duration) ([]QueuedAlert, error)
+	// AckAlerts removes claimed alerts once they were delivered
+	AckAlerts(ids []string) error
+	// ReleaseAlerts gives up the claim on alerts that could not be delivered, so
+	// that they are claimed again on the next delivery
+	ReleaseAlerts(ids []string) error
+	GetQueuedAlertsCount() (int64, error)
+	// Ping reports whether the store can be reached
+	Ping() error
+	// AddHistory records entry and returns it with its ID
+	AddHistory(entry HistoryEntry) (HistoryEntry, error)
+	// GetHistory returns the entries selected by filter, oldest first
+	GetHistory(filter HistoryFilter) ([]HistoryEntry, error)
+}
+
+var _ Store = (*Database)(nil)
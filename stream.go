@@ -0,0 +1,238 @@
+package class_notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream fans the status changes recorded in the history out to live listeners.
+// Listeners that fall behind are dropped rather than slowing the monitor down, and
+// resume from the history with the id of the last change they got.
+type Stream struct {
+	// Buffer is how many changes a listener may fall behind by before it is dropped
+	Buffer int
+
+	mu        sync.Mutex
+	listeners map[*streamListener]struct{}
+}
+
+type streamListener struct {
+	filter  StreamFilter
+	changes chan HistoryEntry
+	// dropped is closed when the listener fell behind
+	dropped chan struct{}
+}
+
+// StreamFilter selects the changes sent to a listener, empty fields match any change
+type StreamFilter struct {
+	Schools []string
+	URIs    []string
+	// Courses match classes whose name starts with one of them, ignoring case and
+	// spaces, such that "cs1332" matches "CS 1332 A"
+	Courses []string
+}
+
+const (
+	defaultStreamBuffer = 64
+	// maxStreamReplay is the most changes read from the store at a time for a
+	// listener resuming from an id
+	maxStreamReplay    = 500
+	streamHeartbeat    = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+func NewStream() *Stream {
+	return &Stream{Buffer: defaultStreamBuffer, listeners: make(map[*streamListener]struct{})}
+}
+
+// Matches reports whether entry is selected by the filter
+func (f StreamFilter) Matches(entry HistoryEntry) bool {
+	if len(f.Schools) > 0 && !contains(f.Schools, entry.School) {
+		return false
+	}
+	if len(f.URIs) > 0 && !contains(f.URIs, entry.URI) {
+		return false
+	}
+	if len(f.Courses) == 0 {
+		return true
+	}
+	name := normalizeCourse(entry.Name)
+	for _, course := range f.Courses {
+		if strings.HasPrefix(name, normalizeCourse(course)) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeCourse(course string) string {
+	return strings.ToLower(strings.Join(strings.Fields(course), ""))
+}
+
+// Publish sends entry to every listener it matches, without waiting on any
+func (s *Stream) Publish(entry HistoryEntry) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l := range s.listeners {
+		if !l.filter.Matches(entry) {
+			continue
+		}
+		select {
+		case l.changes <- entry:
+		default:
+			delete(s.listeners, l)
+			close(l.dropped)
+		}
+	}
+}
+
+func (s *Stream) listen(filter StreamFilter) *streamListener {
+	buffer := s.Buffer
+	if buffer <= 0 {
+		buffer = defaultStreamBuffer
+	}
+	l := &streamListener{
+		filter:  filter,
+		changes: make(chan HistoryEntry, buffer),
+		dropped: make(chan struct{}),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners[l] = struct{}{}
+	return l
+}
+
+func (s *Stream) stop(l *streamListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+// Listeners returns the number of connected listeners
+func (s *Stream) Listeners() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.listeners)
+}
+
+// serve streams the changes selected by the school, uri and course query
+// parameters as server-sent events. Clients resuming with a Last-Event-ID header,
+// or a last_event_id parameter, first get every change they missed from store.
+func (s *Stream) serve(w http.ResponseWriter, r *http.Request, store Store) {
+	query := r.URL.Query()
+	filter := StreamFilter{Schools: query["school"], URIs: query["uri"], Courses: query["course"]}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+
+	// listening before reading the history leaves no gap between the two
+	l := s.listen(filter)
+	defer s.stop(l)
+	// missed reads the changes after id a page at a time, the store filters them so
+	// that the replay limit only counts the ones the client asked for
+	missed := func(afterID string) ([]HistoryEntry, error) {
+		return store.GetHistory(HistoryFilter{
+			URIs:    filter.URIs,
+			Schools: filter.Schools,
+			Courses: filter.Courses,
+			AfterID: afterID,
+			Limit:   maxStreamReplay,
+		})
+	}
+	var page []HistoryEntry
+	if lastID != "" {
+		entries, err := missed(lastID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "unable to resume after event " + lastID})
+			return
+		}
+		page = entries
+	}
+
+	logger := slog.With("remote", r.RemoteAddr, "last_event_id", lastID)
+	logger.Info("stream listener connected")
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	send := func(entry HistoryEntry) error {
+		if entry.ID <= lastID || !filter.Matches(entry) {
+			return nil
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", entry.ID, data); err != nil {
+			return err
+		}
+		lastID = entry.ID
+		return rc.Flush()
+	}
+	for len(page) > 0 {
+		for _, entry := range page {
+			if err := send(entry); err != nil {
+				logger.Info("stream listener left", "err", err)
+				return
+			}
+		}
+		if len(page) < maxStreamReplay {
+			break
+		}
+		var err error
+		if page, err = missed(page[len(page)-1].ID); err != nil {
+			// the client reconnects on its own and resumes from lastID
+			logger.Warn("replaying missed changes failed", "err", err)
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+			rc.Flush()
+			return
+		}
+	}
+	rc.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			logger.Info("stream listener left")
+			return
+		case <-l.dropped:
+			// the client reconnects on its own and resumes from lastID
+			logger.Warn("stream listener fell behind, dropping it")
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+			rc.Flush()
+			return
+		case entry := <-l.changes:
+			err = send(entry)
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			logger.Info("stream listener left", "err", err)
+			return
+		}
+	}
+}
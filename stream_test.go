@@ -0,0 +1,157 @@
+package class_notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zMrKrabz/class-notify/schools"
+)
+
+// readStreamEvent returns the fields of the next event of an event stream with
+// data, skipping comments and the retry delay
+func readStreamEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if fields["data"] != "" {
+				return fields
+			}
+			fields = make(map[string]string)
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ": "); ok && name != "" {
+			fields[name] = value
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	tb, server := newTestAPI(t)
+	stream := NewStream()
+	tb.discord.Bot.Stream = stream
+	seen, _ := tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.OPENED})
+	tb.store.AddHistory(HistoryEntry{URI: otherClass, School: "TEST", Name: "CS 2110", Status: schools.FULL})
+	missed, _ := tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.WAITLISTED})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/stream?course=cs1332", nil)
+	req.Header.Set("Last-Event-ID", seen.ID)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream = %d %s, want an event stream without a key", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+
+	event := readStreamEvent(t, r)
+	var entry HistoryEntry
+	if err := json.Unmarshal([]byte(event["data"]), &entry); err != nil || event["id"] != missed.ID || entry.Status != schools.WAITLISTED {
+		t.Errorf("replayed event = %v, want only the missed change of the course", event)
+	}
+
+	stream.Publish(HistoryEntry{ID: "00000010", URI: otherClass, School: "TEST", Name: "CS 2110", Status: schools.OPENED})
+	stream.Publish(HistoryEntry{ID: "00000011", URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.FULL})
+	event = readStreamEvent(t, r)
+	if event["id"] != "00000011" || event["event"] != "status" {
+		t.Errorf("live event = %v, want the change of the course", event)
+	}
+}
+
+func TestStreamReplayLimit(t *testing.T) {
+	tb, server := newTestAPI(t)
+	tb.discord.Bot.Stream = NewStream()
+	seen, _ := tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.OPENED})
+	// more changes of another class than a replay holds
+	for i := 0; i <= maxStreamReplay; i++ {
+		tb.store.AddHistory(HistoryEntry{URI: otherClass, School: "TEST", Name: "CS 2110", Status: schools.FULL})
+	}
+	missed, _ := tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.WAITLISTED})
+
+	// the stream stays open, reading it fails once no replayed event came in time
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/stream?course=cs1332&last_event_id="+seen.ID, nil)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if event := readStreamEvent(t, bufio.NewReader(resp.Body)); event["id"] != missed.ID {
+		t.Errorf("replayed event = %v, want the missed change of the course", event)
+	}
+}
+
+func TestStreamReplaysEveryMissedChange(t *testing.T) {
+	tb, server := newTestAPI(t)
+	tb.discord.Bot.Stream = NewStream()
+	seen, _ := tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.OPENED})
+	missed := 2*maxStreamReplay + 1
+	var last HistoryEntry
+	for i := 0; i < missed; i++ {
+		last, _ = tb.store.AddHistory(HistoryEntry{URI: testClass, School: "TEST", Name: "CS 1332 A", Status: schools.FULL})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/stream", nil)
+	req.Header.Set("Last-Event-ID", seen.ID)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	var event map[string]string
+	for i := 0; i < missed; i++ {
+		event = readStreamEvent(t, r)
+	}
+	if event["id"] != last.ID {
+		t.Errorf("last replayed event = %v, want %s", event, last.ID)
+	}
+}
+
+func TestCoursePattern(t *testing.T) {
+	pattern := regexp.MustCompile("(?i)" + coursePattern("cs 1332"))
+	for name, want := range map[string]bool{"CS 1332 A": true, "cs1332": true, " CS  1332": true, "CS 13": false, "MATH CS 1332": false} {
+		if pattern.MatchString(name) != want {
+			t.Errorf("%q matched = %t, want %t", name, !want, want)
+		}
+	}
+}
+
+func TestStreamDropsSlowListeners(t *testing.T) {
+	stream := NewStream()
+	stream.Buffer = 1
+	slow := stream.listen(StreamFilter{})
+	filtered := stream.listen(StreamFilter{Schools: []string{"OTHER"}})
+
+	stream.Publish(HistoryEntry{ID: "1", School: "TEST"})
+	stream.Publish(HistoryEntry{ID: "2", School: "TEST"})
+	select {
+	case <-slow.dropped:
+	case <-time.After(time.Second):
+		t.Fatal("listener that fell behind was not dropped")
+	}
+	select {
+	case <-filtered.dropped:
+		t.Error("listener of another school was dropped")
+	default:
+	}
+	if n := stream.Listeners(); n != 1 {
+		t.Errorf("listeners = %d, want 1", n)
+	}
+}